@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops regular syncs after a number of consecutive failures. While it is open, syncs
+// are replaced by probes at a slower cadence, each making a single attempt without retries, and the
+// first successful probe closes it again.
+type circuitBreaker struct {
+	mu            sync.Mutex
+	threshold     int
+	probeInterval time.Duration
+	failures      int
+	state         string
+	logger        *log.Logger
+}
+
+// newCircuitBreaker creates a breaker opening after threshold consecutive failures, or returns nil if disabled
+func newCircuitBreaker(threshold int, probeInterval time.Duration, logger *log.Logger) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, probeInterval: probeInterval, state: breakerClosed, logger: logger}
+}
+
+// begin marks the start of a sync, which becomes a probe while the breaker is open
+func (b *circuitBreaker) begin() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		b.state = breakerHalfOpen
+	}
+}
+
+// record updates the breaker with the result of a sync. A failed probe opens the breaker again.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous := b.state
+	if err == nil {
+		b.failures = 0
+		b.state = breakerClosed
+		if previous != breakerClosed {
+			b.logger.Printf("Probe sync succeeded, closing circuit breaker")
+		}
+		return
+	}
+
+	b.failures++
+	if previous == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+	}
+	if previous == breakerClosed && b.state == breakerOpen {
+		b.logger.Printf("%d consecutive syncs failed, opening circuit breaker and probing every %v", b.failures, b.probeInterval)
+	}
+}
+
+// currentState returns the state of the breaker, or an empty string if it is disabled
+func (b *circuitBreaker) currentState() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isOpen reports whether syncs are replaced by probes
+func (b *circuitBreaker) isOpen() bool {
+	state := b.currentState()
+	return state == breakerOpen || state == breakerHalfOpen
+}
+
+// probing reports whether the running sync is a probe, which makes a single attempt per request
+func (b *circuitBreaker) probing() bool {
+	return b.currentState() == breakerHalfOpen
+}
+
+// breakerDescription describes the circuit breaker configuration for the health endpoint
+func (app *ConfsyncApp) breakerDescription() string {
+	if app.breaker == nil {
+		return "disabled"
+	}
+	return fmt.Sprintf("open after %d failed syncs, probe every %v", app.breaker.threshold, app.breaker.probeInterval)
+}
+
+// breakerStateMetric returns a gauge per breaker state, set to 1 for the current state
+func breakerStateMetric(state string) metricFamily {
+	family := metricFamily{name: "confsync_breaker_state", kind: "gauge", help: "Current state of the circuit breaker"}
+	for _, s := range []string{breakerClosed, breakerOpen, breakerHalfOpen} {
+		value := 0
+		if s == state {
+			value = 1
+		}
+		family.samples = append(family.samples, metricSample{labels: fmt.Sprintf("state=%q", s), value: fmt.Sprintf("%d", value)})
+	}
+	return family
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	breaker := newCircuitBreaker(2, time.Minute, log.Default())
+	failure := errors.New("remote down")
+
+	breaker.begin()
+	breaker.record(failure)
+	if state := breaker.currentState(); state != breakerClosed {
+		t.Fatalf("Expected the breaker to stay closed after one failure, got %s", state)
+	}
+	breaker.begin()
+	breaker.record(failure)
+	if state := breaker.currentState(); state != breakerOpen {
+		t.Fatalf("Expected the breaker to open after two failures, got %s", state)
+	}
+
+	// A failed probe opens the breaker again
+	breaker.begin()
+	if !breaker.probing() {
+		t.Fatalf("Expected a sync of an open breaker to be a probe")
+	}
+	breaker.record(failure)
+	if state := breaker.currentState(); state != breakerOpen {
+		t.Fatalf("Expected a failed probe to open the breaker, got %s", state)
+	}
+
+	breaker.begin()
+	breaker.record(nil)
+	if state := breaker.currentState(); state != breakerClosed {
+		t.Fatalf("Expected a successful probe to close the breaker, got %s", state)
+	}
+
+	if newCircuitBreaker(0, time.Minute, log.Default()) != nil {
+		t.Errorf("Expected a threshold of 0 to disable the breaker")
+	}
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	var requests int32
+	var down int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:        server.URL + "/",
+		LocalDir:         t.TempDir(),
+		PollInterval:     time.Minute,
+		MaxRetries:       2,
+		RetryDelay:       time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerProbe:     time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := app.runSync("Sync"); err == nil {
+			t.Fatalf("Expected sync %d to fail", i)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 6 {
+		t.Errorf("Expected 3 attempts per sync, got %d requests", got)
+	}
+	if health := app.getHealthStatus(); health.Breaker != breakerOpen {
+		t.Fatalf("Expected the breaker to be open, got %q", health.Breaker)
+	}
+	if delay := app.nextSyncDelay(time.Now()); delay != time.Hour {
+		t.Errorf("Expected the next sync after the probe interval, got %v", delay)
+	}
+
+	// Probes make a single attempt
+	atomic.StoreInt32(&requests, 0)
+	if err := app.runSync("Sync"); err == nil {
+		t.Fatalf("Expected the probe to fail")
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Expected a single probe request, got %d", got)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	if err := app.runSync("Sync"); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	if health := app.getHealthStatus(); health.Breaker != breakerClosed {
+		t.Errorf("Expected the breaker to be closed, got %q", health.Breaker)
+	}
+	if delay := app.nextSyncDelay(time.Now()); delay != time.Minute {
+		t.Errorf("Expected the poll interval once the breaker closed, got %v", delay)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errNotModified is returned by downloadFile when the server answers a conditional request with 304
+var errNotModified = errors.New("not modified")
+
+// conditionalEntry holds the validators of the last successful response for a URL and,
+// for listings, the entries parsed from it
+type conditionalEntry struct {
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Entries      []FileEntry `json:"entries,omitempty"`
+}
+
+// addConditionalHeaders sets If-None-Match and If-Modified-Since from the validators remembered for the request URL
+func (app *ConfsyncApp) addConditionalHeaders(req *http.Request) {
+	if !app.config.Conditional {
+		return
+	}
+
+	app.conditionalMu.Lock()
+	entry, exists := app.conditionalCache[req.URL.String()]
+	app.conditionalMu.Unlock()
+	if !exists {
+		return
+	}
+
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// rememberValidators stores the ETag and Last-Modified of a successful response, along with the
+// listing entries parsed from it (nil for files). Responses without validators are forgotten.
+func (app *ConfsyncApp) rememberValidators(requestURL string, header http.Header, entries []FileEntry) {
+	if !app.config.Conditional {
+		return
+	}
+
+	app.conditionalMu.Lock()
+	defer app.conditionalMu.Unlock()
+
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		delete(app.conditionalCache, requestURL)
+		return
+	}
+
+	app.conditionalCache[requestURL] = conditionalEntry{
+		ETag:         etag,
+		LastModified: lastModified,
+		Entries:      entries,
+	}
+}
+
+// forgetValidators drops the validators remembered for a URL, forcing the next request to be unconditional
+func (app *ConfsyncApp) forgetValidators(requestURL string) {
+	app.conditionalMu.Lock()
+	delete(app.conditionalCache, requestURL)
+	app.conditionalMu.Unlock()
+}
+
+// cachedListing returns the entries remembered for a listing URL, used when the server answers 304
+func (app *ConfsyncApp) cachedListing(requestURL string) ([]FileEntry, bool) {
+	app.conditionalMu.Lock()
+	defer app.conditionalMu.Unlock()
+
+	entry, exists := app.conditionalCache[requestURL]
+	if !exists || entry.Entries == nil {
+		return nil, false
+	}
+	return append([]FileEntry(nil), entry.Entries...), true
+}
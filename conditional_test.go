@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	listing := `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`
+	listingETag := `"listing-1"`
+	modTime := time.Date(2025, 7, 27, 4, 23, 20, 0, time.UTC)
+
+	var notModified, fullResponses int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		if r.URL.Path == "/" {
+			recorder.Header().Set("ETag", listingETag)
+			recorder.Header().Set("Content-Type", "application/json")
+			http.ServeContent(recorder, r, "", time.Time{}, bytes.NewReader([]byte(listing)))
+		} else {
+			recorder.Header().Set("ETag", `"a-1"`)
+			http.ServeContent(recorder, r, "a.yaml", modTime, bytes.NewReader([]byte("a\n")))
+		}
+
+		if recorder.Code == http.StatusNotModified {
+			notModified++
+		} else {
+			fullResponses++
+		}
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: ".*",
+		Conditional: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	if fullResponses != 2 || notModified != 0 {
+		t.Fatalf("Expected 2 full responses on initial sync, got %d full and %d not modified", fullResponses, notModified)
+	}
+
+	// Unchanged listing: answered with 304 and the cached entries are reused
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if fullResponses != 2 || notModified != 1 {
+		t.Errorf("Expected the listing to be answered with 304, got %d full and %d not modified", fullResponses, notModified)
+	}
+	if _, ok := app.fileCache["a.yaml"]; !ok {
+		t.Errorf("Expected cached listing entries to be reused after 304")
+	}
+
+	// The listing changes but the file doesn't: the file is answered with 304 and left alone
+	listing = `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:30:00 GMT", "size": 2}]`
+	listingETag = `"listing-2"`
+	localPath := filepath.Join(localDir, "a.yaml")
+	marker := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(localPath, marker, marker); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Third sync failed: %v", err)
+	}
+	if fullResponses != 3 || notModified != 2 {
+		t.Errorf("Expected a full listing and a 304 for the file, got %d full and %d not modified", fullResponses, notModified)
+	}
+	if info, err := os.Stat(localPath); err != nil || !info.ModTime().Equal(marker) {
+		t.Errorf("Expected unmodified file not to be rewritten")
+	}
+	if cached := app.fileCache["a.yaml"]; cached.MTime != "Sun, 27 Jul 2025 04:30:00 GMT" {
+		t.Errorf("Expected cache to be updated after 304, got %+v", cached)
+	}
+
+}
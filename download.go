@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleChunk is the most a throttled reader reads at once, which keeps the rate smooth
+const throttleChunk = 32 * 1024
+
+// errDownloadSkipped marks downloads that weren't started because another download of a transaction failed
+var errDownloadSkipped = errors.New("download skipped")
+
+// downloadErrors aggregates the errors of the downloads that failed in a sync
+type downloadErrors []error
+
+func (e downloadErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to download %d files: %s", len(e), strings.Join(messages, "; "))
+}
+
+func (e downloadErrors) Unwrap() []error {
+	return e
+}
+
+// downloadFiles downloads the entries into root with up to Concurrency parallel workers, each retrying
+// failed downloads and failing over to other mirrors, and returns the error of each download, in the order of entries. No new downloads
+// are started once the download context is cancelled or, with stopOnError, a download failed.
+func (app *ConfsyncApp) downloadFiles(entries []FileEntry, root string, stopOnError bool) []error {
+	results := make([]error, len(entries))
+	workers := app.config.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(entries) {
+		workers = len(entries)
+	}
+
+	var failed int32
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := app.downloadFromSource(entries[i], root)
+				if err != nil && !errors.Is(err, errNotModified) && stopOnError {
+					atomic.StoreInt32(&failed, 1)
+				}
+				results[i] = err
+			}
+		}()
+	}
+
+	ctx := app.downloadCtx
+	for i, entry := range entries {
+		switch {
+		case ctx.Err() != nil:
+			results[i] = fmt.Errorf("download of %s was cancelled", entry.Name)
+		case atomic.LoadInt32(&failed) == 1:
+			results[i] = errDownloadSkipped
+		default:
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// parseBandwidth parses a rate in bytes per second with an optional K, M or G suffix (powers of 1024)
+func parseBandwidth(value string) (int64, error) {
+	original := value
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q: expected bytes per second with an optional K, M or G suffix", original)
+	}
+	return rate * multiplier, nil
+}
+
+// rateLimiter is a token bucket shared by all downloads, refilled at rate bytes per second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter for the given rate, or returns nil for an unlimited rate
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: throttleChunk, last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, waiting for the bucket to refill if it ran dry
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > throttleChunk {
+		l.tokens = throttleChunk
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader limits reads from r to the rate of a shared limiter
+type throttledReader struct {
+	r       io.Reader
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentDownloads(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			var entries []string
+			for i := 0; i < 8; i++ {
+				entries = append(entries, fmt.Sprintf(`{"name": "f%d.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}`, i))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if r.URL.Path == "/f3.yaml" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "x\n")
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+		Concurrency: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if maxInFlight != 4 {
+		t.Errorf("Expected 4 concurrent downloads, got %d", maxInFlight)
+	}
+
+	health := app.getHealthStatus()
+	if health.SyncedFiles != 7 || health.FailedDownloads != 1 {
+		t.Errorf("Expected 7 synced files and 1 failed download, got %d and %d", health.SyncedFiles, health.FailedDownloads)
+	}
+	for i := 0; i < 8; i++ {
+		_, err := os.Stat(filepath.Join(localDir, fmt.Sprintf("f%d.yaml", i)))
+		if exists := err == nil; exists != (i != 3) {
+			t.Errorf("Unexpected state of f%d.yaml: %v", i, err)
+		}
+	}
+	// The failed file is retried on the next sync
+	if _, cached := app.fileCache["f3.yaml"]; cached {
+		t.Errorf("Expected the failed download not to be cached")
+	}
+}
+
+func TestTransactionalConcurrentDownloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "c.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		if r.URL.Path == "/b.yaml" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "x\n")
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:     server.URL + "/",
+		LocalDir:      localDir,
+		FilePattern:   `\.yaml$`,
+		Concurrency:   3,
+		Transactional: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err == nil || !strings.Contains(err.Error(), "transaction aborted") {
+		t.Fatalf("Expected the transaction to be aborted, got %v", err)
+	}
+	entries, err := os.ReadDir(localDir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no changes to be applied, got %v (%v)", entries, err)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	content := strings.Repeat("x", 96*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"name": "big.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": %d}]`, len(content))
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:    server.URL + "/",
+		LocalDir:     t.TempDir(),
+		FilePattern:  `\.yaml$`,
+		MaxBandwidth: "128K",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// 32K pass immediately, the remaining 64K take half a second
+	start := time.Now()
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the download to be throttled, took %v", elapsed)
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	for value, want := range map[string]int64{"": 0, "0": 0, "1000": 1000, "512K": 512 * 1024, "10m": 10 << 20, "1G": 1 << 30} {
+		if got, err := parseBandwidth(value); err != nil || got != want {
+			t.Errorf("parseBandwidth(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"fast", "-1", "10MB"} {
+		if _, err := parseBandwidth(value); err == nil {
+			t.Errorf("Expected parseBandwidth(%q) to fail", value)
+		}
+	}
+}
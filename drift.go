@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Supported drift check modes, from cheapest to most thorough
+const (
+	driftCheckOff   = "off"
+	driftCheckSize  = "size"
+	driftCheckMTime = "mtime"
+	driftCheckHash  = "hash"
+)
+
+// Supported actions for drifted files
+const (
+	driftActionRepair = "repair"
+	driftActionReport = "report"
+)
+
+// validateDriftConfig checks the drift check mode and action
+func validateDriftConfig(config *Config) error {
+	switch config.DriftCheck {
+	case "":
+		config.DriftCheck = driftCheckOff
+	case driftCheckOff, driftCheckSize, driftCheckMTime, driftCheckHash:
+	default:
+		return fmt.Errorf("invalid drift check %q: must be one of off, size, mtime, hash", config.DriftCheck)
+	}
+
+	switch config.DriftAction {
+	case "":
+		config.DriftAction = driftActionRepair
+	case driftActionRepair, driftActionReport:
+	default:
+		return fmt.Errorf("invalid drift action %q: must be one of repair, report", config.DriftAction)
+	}
+
+	return nil
+}
+
+// fileDrifted reports whether a synced file was modified or removed locally since confsync
+// last wrote it. Files without a recorded local state can't be checked and never drift.
+func (app *ConfsyncApp) fileDrifted(filename string) bool {
+	app.localMu.Lock()
+	recorded, known := app.localFiles[filename]
+	app.localMu.Unlock()
+	if !known {
+		return false
+	}
+
+	localPath := filepath.Join(app.config.LocalDir, filepath.FromSlash(filename))
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != recorded.Size {
+		return true
+	}
+
+	switch app.config.DriftCheck {
+	case driftCheckMTime:
+		return !info.ModTime().Equal(recorded.ModTime)
+	case driftCheckHash:
+		digest, err := fileSHA256(localPath)
+		return err != nil || digest != recorded.SHA256
+	default:
+		return false
+	}
+}
+
+// checkDrift returns the files of the new cache that drifted locally and aren't already being synced
+func (app *ConfsyncApp) checkDrift(newCache map[string]FileEntry, filesToSync []FileEntry) []FileEntry {
+	if app.config.DriftCheck == driftCheckOff {
+		return nil
+	}
+
+	scheduled := make(map[string]bool, len(filesToSync))
+	for _, entry := range filesToSync {
+		scheduled[entry.Name] = true
+	}
+
+	var drifted []FileEntry
+	for filename, entry := range newCache {
+		if !scheduled[filename] && app.fileDrifted(filename) {
+			drifted = append(drifted, entry)
+		}
+	}
+	return drifted
+}
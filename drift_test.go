@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDriftDetection(t *testing.T) {
+	files := map[string]string{"a.yaml": "a\n", "b.yaml": "b\n", "c.yaml": "c\n"}
+	var mu sync.Mutex
+	downloads := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "c.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		name := r.URL.Path[1:]
+		mu.Lock()
+		downloads[name]++
+		mu.Unlock()
+		fmt.Fprint(w, files[name])
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		check       string
+		action      string
+		wantDrifted int64
+		wantRepair  bool
+	}{
+		// Size mode misses same-size edits with the original timestamp
+		{"size", driftCheckSize, driftActionRepair, 1, true},
+		{"mtime", driftCheckMTime, driftActionRepair, 1, true},
+		{"hash", driftCheckHash, driftActionRepair, 2, true},
+		{"report", driftCheckHash, driftActionReport, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			downloads = make(map[string]int)
+			mu.Unlock()
+
+			localDir := t.TempDir()
+			app, err := NewConfsyncApp(Config{
+				RemoteURL:   server.URL + "/",
+				LocalDir:    localDir,
+				FilePattern: ".*",
+				DriftCheck:  tt.check,
+				DriftAction: tt.action,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create app: %v", err)
+			}
+
+			if err := app.syncFiles(); err != nil {
+				t.Fatalf("Initial sync failed: %v", err)
+			}
+
+			// Delete a.yaml, and edit b.yaml in place keeping its size and timestamp
+			if err := os.Remove(filepath.Join(localDir, "a.yaml")); err != nil {
+				t.Fatal(err)
+			}
+			bPath := filepath.Join(localDir, "b.yaml")
+			info, err := os.Stat(bPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(bPath, []byte("x\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(bPath, time.Now(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := app.syncFiles(); err != nil {
+				t.Fatalf("Second sync failed: %v", err)
+			}
+			if drifted := app.getHealthStatus().DriftedFiles; drifted != tt.wantDrifted {
+				t.Errorf("Expected %d drifted files, got %d", tt.wantDrifted, drifted)
+			}
+
+			_, err = os.Stat(filepath.Join(localDir, "a.yaml"))
+			if restored := err == nil; restored != tt.wantRepair {
+				t.Errorf("Expected a.yaml restored to be %v, got %v", tt.wantRepair, restored)
+			}
+			if downloads["c.yaml"] != 1 {
+				t.Errorf("Expected untouched c.yaml to be downloaded once, got %d", downloads["c.yaml"])
+			}
+
+			if tt.wantRepair {
+				if err := app.syncFiles(); err != nil {
+					t.Fatalf("Third sync failed: %v", err)
+				}
+				if drifted := app.getHealthStatus().DriftedFiles; drifted != 0 {
+					t.Errorf("Expected no drifted files after repair, got %d", drifted)
+				}
+			}
+		})
+	}
+
+	if _, err := NewConfsyncApp(Config{FilePattern: ".*", DriftCheck: "checksum"}); err == nil {
+		t.Errorf("Expected an invalid drift check mode to be rejected")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// allWeekdays is the weekday bitset of a freeze window without days
+const allWeekdays = 1<<7 - 1
+
+// freezeWindow is a recurring period during which changes are detected but not applied. A window
+// without a time range covers the whole day; one ending before it starts extends into the next day.
+type freezeWindow struct {
+	days       uint8
+	start, end int
+	allDay     bool
+}
+
+// parseFreezeWindows parses semicolon-separated windows such as "Mon-Fri 18:00-08:00; Sat,Sun"
+func parseFreezeWindows(spec string) ([]freezeWindow, error) {
+	var windows []freezeWindow
+	for _, part := range strings.Split(spec, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		window, err := parseFreezeWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid freeze window %q: %w", part, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseFreezeWindow parses a single window made of days, a time range, or both
+func parseFreezeWindow(spec string) (freezeWindow, error) {
+	window := freezeWindow{days: allWeekdays, allDay: true}
+	fields := strings.Fields(spec)
+	if len(fields) > 2 {
+		return window, fmt.Errorf("expected days and/or a time range")
+	}
+
+	for i, field := range fields {
+		var err error
+		if strings.Contains(field, ":") {
+			if !window.allDay {
+				return window, fmt.Errorf("more than one time range")
+			}
+			window.allDay = false
+			window.start, window.end, err = parseTimeRange(field)
+		} else {
+			if i > 0 {
+				return window, fmt.Errorf("days must come before the time range")
+			}
+			window.days, err = parseWeekdays(field)
+		}
+		if err != nil {
+			return window, err
+		}
+	}
+	return window, nil
+}
+
+// parseWeekdays parses a comma-separated list of days and day ranges (e.g. Mon-Fri,Sun) into a bitset.
+// Ranges may wrap around the end of the week.
+func parseWeekdays(spec string) (uint8, error) {
+	var days uint8
+	for _, part := range strings.Split(spec, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdayNames[strings.ToUpper(first)]
+		if !ok {
+			return 0, fmt.Errorf("unknown day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[strings.ToUpper(last)]; !ok {
+				return 0, fmt.Errorf("unknown day %q", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days |= 1 << uint(day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseTimeRange parses HH:MM-HH:MM into minutes since midnight. The end may be 24:00.
+func parseTimeRange(spec string) (int, int, error) {
+	startPart, endPart, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a time range like 18:00-08:00")
+	}
+	start, err := parseClock(startPart)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(endPart)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end || start == 24*60 {
+		return 0, 0, fmt.Errorf("empty time range %q", spec)
+	}
+	return start, end, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string) (int, error) {
+	hourPart, minutePart, ok := strings.Cut(value, ":")
+	hour, hourErr := strconv.Atoi(hourPart)
+	minute, minuteErr := strconv.Atoi(minutePart)
+	if !ok || hourErr != nil || minuteErr != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// contains reports whether t falls into the window
+func (w freezeWindow) contains(t time.Time) bool {
+	day := uint(t.Weekday())
+	if w.allDay {
+		return w.days&(1<<day) != 0
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days&(1<<day) != 0 && minute >= w.start && minute < w.end
+	}
+	// Overnight windows belong to the day they start on
+	yesterday := (day + 6) % 7
+	return (w.days&(1<<day) != 0 && minute >= w.start) || (w.days&(1<<yesterday) != 0 && minute < w.end)
+}
+
+// frozen reports whether t falls into any freeze window
+func (app *ConfsyncApp) frozen(t time.Time) bool {
+	for _, window := range app.freezeWindows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferChanges records the changes a sync found during a freeze window instead of applying them
+func (app *ConfsyncApp) deferChanges(filesToSync []FileEntry, filesToRemove []string) {
+	names := make([]string, 0, len(filesToSync))
+	for _, entry := range filesToSync {
+		names = append(names, entry.Name)
+	}
+	deferred := app.describeChanges(names, filesToRemove)
+
+	app.mu.Lock()
+	first := app.deferred == nil
+	app.deferred = &deferred
+	app.lastSync = time.Now()
+	app.lastError = ""
+	app.mu.Unlock()
+
+	if first || app.config.Verbose {
+		app.logger.Printf("Freeze window active, deferring %d added, %d changed and %d removed files",
+			len(deferred.Added), len(deferred.Changed), len(deferred.Removed))
+	}
+}
+
+// clearDeferredChanges forgets deferred changes once they were applied or are gone
+func (app *ConfsyncApp) clearDeferredChanges() {
+	app.mu.Lock()
+	app.deferred = nil
+	app.mu.Unlock()
+}
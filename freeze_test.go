@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreezeWindows(t *testing.T) {
+	windows, err := parseFreezeWindows("Mon-Fri 18:00-08:00; Sat,Sun")
+	if err != nil {
+		t.Fatalf("Failed to parse freeze windows: %v", err)
+	}
+	app := &ConfsyncApp{freezeWindows: windows}
+
+	tests := []struct {
+		time   time.Time
+		frozen bool
+	}{
+		{time.Date(2025, 7, 30, 12, 0, 0, 0, time.UTC), false}, // Wednesday noon
+		{time.Date(2025, 7, 30, 18, 0, 0, 0, time.UTC), true},  // Wednesday evening
+		{time.Date(2025, 7, 31, 7, 59, 0, 0, time.UTC), true},  // Thursday morning, still Wednesday's window
+		{time.Date(2025, 7, 28, 7, 0, 0, 0, time.UTC), false},  // Monday morning, Sunday has no overnight window
+		{time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC), true},   // Saturday
+		{time.Date(2025, 8, 2, 7, 0, 0, 0, time.UTC), true},    // Saturday morning, Friday's window and Saturday
+	}
+	for _, test := range tests {
+		if frozen := app.frozen(test.time); frozen != test.frozen {
+			t.Errorf("frozen(%v) = %t, want %t", test.time.Format("Mon 15:04"), frozen, test.frozen)
+		}
+	}
+
+	for _, spec := range []string{"Funday", "Mon 18:00", "25:00-08:00", "10:00-10:00", "08:00-09:00 Mon", "Mon Tue"} {
+		if _, err := parseFreezeWindows(spec); err == nil {
+			t.Errorf("Expected parsing %q to fail", spec)
+		}
+	}
+}
+
+func TestFreezeDefersChanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		fmt.Fprint(w, "a\n")
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:    server.URL + "/",
+		LocalDir:     localDir,
+		FilePattern:  `\.yaml$`,
+		PollInterval: time.Minute,
+		Freeze:       "Sun-Sat",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Frozen sync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "a.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.yaml not to be written during a freeze window")
+	}
+	health := app.getHealthStatus()
+	if !health.Frozen || health.Deferred == nil || fmt.Sprint(health.Deferred.Added) != "[a.yaml]" {
+		t.Errorf("Expected a.yaml to be reported as deferred, got frozen=%t deferred=%+v", health.Frozen, health.Deferred)
+	}
+
+	// Once the window is over the deferred changes are applied
+	app.freezeWindows = nil
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync after the freeze failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "a.yaml")); err != nil {
+		t.Errorf("Expected a.yaml to be written after the freeze: %v", err)
+	}
+	if health := app.getHealthStatus(); health.Frozen || health.Deferred != nil {
+		t.Errorf("Expected no deferred changes after the freeze, got %+v", health.Deferred)
+	}
+}
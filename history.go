@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of a history generation: <history-dir>/<n>/metadata.json and <history-dir>/<n>/files/...
+const (
+	historyMetadataFile = "metadata.json"
+	historyFilesDir     = "files"
+	historyPinFile      = "pinned"
+)
+
+// errNoGeneration is returned when a requested history generation doesn't exist
+var errNoGeneration = errors.New("no such generation")
+
+// historyGeneration describes one committed set of synced files kept for rollback
+type historyGeneration struct {
+	Generation int            `json:"generation"`
+	Created    time.Time      `json:"created"`
+	Files      []FileEntry    `json:"files"`
+	Sources    map[string]int `json:"sources,omitempty"`
+}
+
+// historyPath returns the location of the history directory, or "" when history is disabled.
+// Relative paths are resolved against the local directory.
+func (app *ConfsyncApp) historyPath() string {
+	if app.config.History <= 0 || app.config.HistoryDir == "" {
+		return ""
+	}
+	if filepath.IsAbs(app.config.HistoryDir) {
+		return app.config.HistoryDir
+	}
+	return filepath.Join(app.config.LocalDir, app.config.HistoryDir)
+}
+
+// generationPath returns the directory of a history generation
+func (app *ConfsyncApp) generationPath(generation int) string {
+	return filepath.Join(app.historyPath(), strconv.Itoa(generation))
+}
+
+// listHistory returns the numbers of the generations kept in history, oldest first
+func (app *ConfsyncApp) listHistory() ([]int, error) {
+	entries, err := os.ReadDir(app.historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var generations []int
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() && n > 0 {
+			generations = append(generations, n)
+		}
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+// loadGeneration reads the metadata of a history generation
+func (app *ConfsyncApp) loadGeneration(generation int) (*historyGeneration, error) {
+	data, err := os.ReadFile(filepath.Join(app.generationPath(generation), historyMetadataFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %d", errNoGeneration, generation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generation %d: %w", generation, err)
+	}
+
+	var gen historyGeneration
+	if err := json.Unmarshal(data, &gen); err != nil {
+		return nil, fmt.Errorf("failed to parse generation %d: %w", generation, err)
+	}
+	for i, entry := range gen.Files {
+		if !isSafeRelPath(entry.Name) {
+			return nil, fmt.Errorf("generation %d has unsafe file name %q", generation, entry.Name)
+		}
+		gen.Files[i].Source = gen.Sources[entry.Name]
+	}
+	return &gen, nil
+}
+
+// recordHistory copies the committed set of synced files into a new history generation
+// and prunes generations beyond the configured number
+func (app *ConfsyncApp) recordHistory(entries map[string]FileEntry) error {
+	generations, err := app.listHistory()
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(generations) > 0 {
+		next = generations[len(generations)-1] + 1
+	}
+
+	gen := historyGeneration{Generation: next, Created: time.Now()}
+	tmpDir := app.generationPath(next) + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return fmt.Errorf("failed to remove stale %s: %w", tmpDir, err)
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(app.config.LocalDir, filepath.FromSlash(entry.Name))
+		dst := filepath.Join(tmpDir, historyFilesDir, filepath.FromSlash(entry.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dst), err)
+		}
+		// Files that failed to sync and were never written locally aren't part of the generation
+		if err := copyFile(src, dst); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to copy %s to history: %w", entry.Name, err)
+		}
+		gen.Files = append(gen.Files, entry)
+		if entry.Source != 0 {
+			if gen.Sources == nil {
+				gen.Sources = make(map[string]int)
+			}
+			gen.Sources[entry.Name] = entry.Source
+		}
+	}
+	sort.Slice(gen.Files, func(i, j int) bool { return gen.Files[i].Name < gen.Files[j].Name })
+
+	data, err := json.MarshalIndent(gen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode generation: %w", err)
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", tmpDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, historyMetadataFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write generation metadata: %w", err)
+	}
+	if err := os.Rename(tmpDir, app.generationPath(next)); err != nil {
+		return fmt.Errorf("failed to move generation into place: %w", err)
+	}
+
+	if app.config.Verbose {
+		app.logger.Printf("Recorded history generation %d (%d files)", next, len(gen.Files))
+	}
+
+	app.pruneHistory(append(generations, next))
+	return nil
+}
+
+// pruneHistory removes the oldest generations beyond the configured number, never the pinned one
+func (app *ConfsyncApp) pruneHistory(generations []int) {
+	pinned, _ := app.pinnedGeneration()
+	for len(generations) > app.config.History {
+		oldest := generations[0]
+		generations = generations[1:]
+		if oldest == pinned {
+			continue
+		}
+		if err := os.RemoveAll(app.generationPath(oldest)); err != nil {
+			app.logger.Printf("Error removing history generation %d: %v", oldest, err)
+		}
+	}
+}
+
+// pinnedGeneration returns the generation the local directory is pinned to, or 0 when not pinned
+func (app *ConfsyncApp) pinnedGeneration() (int, error) {
+	if app.historyPath() == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(app.historyPath(), historyPinFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pin: %w", err)
+	}
+
+	generation, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pin %q", strings.TrimSpace(string(data)))
+	}
+	return generation, nil
+}
+
+// writePin pins the local directory to a generation, or releases the pin when generation is 0
+func (app *ConfsyncApp) writePin(generation int) error {
+	path := filepath.Join(app.historyPath(), historyPinFile)
+	if generation == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to release pin: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.WriteFile(path+".tmp", []byte(strconv.Itoa(generation)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write pin: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write pin: %w", err)
+	}
+	return nil
+}
+
+// checkPin reports whether syncing is paused by a pin. When a pin has been released since the
+// last check, the caches are reset so the next sync brings every file back in line with the remote.
+func (app *ConfsyncApp) checkPin() bool {
+	pinned, err := app.pinnedGeneration()
+	if err != nil {
+		app.logger.Printf("Warning: ignoring pin: %v", err)
+	}
+
+	app.mu.Lock()
+	previous := app.pinned
+	app.pinned = pinned
+	app.mu.Unlock()
+
+	switch {
+	case pinned != 0 && pinned != previous:
+		app.logger.Printf("Pinned to history generation %d, syncing is paused until the pin is released", pinned)
+	case pinned == 0 && previous != 0:
+		app.logger.Printf("Pin on generation %d released, resuming sync", previous)
+		app.resetCaches()
+	}
+	return pinned != 0
+}
+
+// resetCaches forgets everything known about synced files and cached responses
+func (app *ConfsyncApp) resetCaches() {
+	app.fileCache = make(map[string]FileEntry)
+
+	app.localMu.Lock()
+	app.localFiles = make(map[string]localFileInfo)
+	app.localMu.Unlock()
+
+	app.conditionalMu.Lock()
+	app.conditionalCache = make(map[string]conditionalEntry)
+	app.conditionalMu.Unlock()
+}
+
+// rollback restores a history generation into the local directory and pins it. A target of 0
+// selects the generation before the active one.
+func (app *ConfsyncApp) rollback(target int) (int, error) {
+	if app.historyPath() == "" {
+		return 0, fmt.Errorf("history is disabled")
+	}
+
+	app.syncMu.Lock()
+	defer app.syncMu.Unlock()
+
+	generations, err := app.listHistory()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(generations) == 0 {
+		return 0, fmt.Errorf("%w: history is empty", errNoGeneration)
+	}
+
+	if target == 0 {
+		active, err := app.pinnedGeneration()
+		if err != nil {
+			return 0, err
+		}
+		if active == 0 {
+			active = generations[len(generations)-1]
+		}
+		for _, generation := range generations {
+			if generation < active {
+				target = generation
+			}
+		}
+		if target == 0 {
+			return 0, fmt.Errorf("%w: no generation before %d", errNoGeneration, active)
+		}
+	}
+
+	gen, err := app.loadGeneration(target)
+	if err != nil {
+		return 0, err
+	}
+	if err := app.restoreGeneration(gen); err != nil {
+		return 0, err
+	}
+	if err := app.writePin(target); err != nil {
+		return 0, err
+	}
+
+	app.mu.Lock()
+	app.pinned = target
+	app.mu.Unlock()
+
+	app.logger.Printf("Rolled back to history generation %d (%d files), pinned until released", target, len(gen.Files))
+	return target, nil
+}
+
+// releasePin releases a pin so the next sync follows the remote again
+func (app *ConfsyncApp) releasePin() error {
+	if app.historyPath() == "" {
+		return fmt.Errorf("history is disabled")
+	}
+
+	app.syncMu.Lock()
+	defer app.syncMu.Unlock()
+
+	return app.writePin(0)
+}
+
+// restoreGeneration replaces the synced files in the local directory with those of a history
+// generation, using a new snapshot generation or a transaction so the set changes as a whole
+func (app *ConfsyncApp) restoreGeneration(gen *historyGeneration) error {
+	source := filepath.Join(app.generationPath(gen.Generation), historyFilesDir)
+	restored := make(map[string]FileEntry, len(gen.Files))
+	for _, entry := range gen.Files {
+		restored[entry.Name] = entry
+	}
+
+	var removals []string
+	if app.config.DeleteFiles && !app.config.Snapshot {
+		localFiles, err := app.scanMatchingFiles()
+		if err != nil {
+			return fmt.Errorf("failed to scan local directory: %w", err)
+		}
+		for _, filename := range localFiles {
+			if _, exists := restored[filename]; !exists {
+				removals = append(removals, filename)
+			}
+		}
+	}
+
+	var generation string
+	var err error
+	if app.config.Snapshot {
+		if generation, err = app.newGeneration(); err != nil {
+			return err
+		}
+	}
+
+	tx, err := app.beginTransaction(gen.Files, removals, generation)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range gen.Files {
+		dst := filepath.Join(tx.root, filepath.FromSlash(entry.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			tx.abort()
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dst), err)
+		}
+		if err := copyFile(filepath.Join(source, filepath.FromSlash(entry.Name)), dst); err != nil {
+			tx.abort()
+			return fmt.Errorf("failed to restore %s: %w", entry.Name, err)
+		}
+	}
+
+	if app.config.Snapshot {
+		// Without -delete, files outside the restored set are carried over like during a sync
+		var previousFiles []string
+		previous := app.currentGeneration()
+		if previous != "" && !app.config.DeleteFiles {
+			if previousFiles, err = listGeneration(previous); err != nil {
+				tx.abort()
+				return fmt.Errorf("failed to list generation %s: %w", previous, err)
+			}
+		}
+		if err := app.commitGeneration(generation, previous, previousFiles, nil, true); err != nil {
+			tx.restoreRecords()
+			return err
+		}
+	} else {
+		names := make([]string, 0, len(gen.Files))
+		for _, entry := range gen.Files {
+			names = append(names, entry.Name)
+		}
+		if err := tx.commit(names, removals); err != nil {
+			return err
+		}
+	}
+
+	for _, filename := range removals {
+		app.forgetLocalFile(filename)
+	}
+	for _, entry := range gen.Files {
+		if info, err := statLocalFile(filepath.Join(app.config.LocalDir, filepath.FromSlash(entry.Name))); err == nil {
+			app.recordLocalFile(entry.Name, info)
+		}
+	}
+
+	// In snapshot mode with -delete, files outside the restored set were left out of the generation
+	if app.config.Snapshot && app.config.DeleteFiles {
+		for filename := range app.fileCache {
+			if _, exists := restored[filename]; !exists {
+				removals = append(removals, filename)
+			}
+		}
+	}
+	var written []string
+	for _, entry := range gen.Files {
+		if cached, exists := app.fileCache[entry.Name]; !exists || entryChanged(cached, entry) {
+			written = append(written, entry.Name)
+		}
+	}
+	changes := app.describeChanges(written, removals)
+	app.fileCache = restored
+	app.setFileSources(restored)
+
+	if err := app.saveState(); err != nil {
+		app.logger.Printf("Warning: could not save sync state: %v", err)
+	}
+
+	if !changes.empty() {
+		app.afterChange(changes)
+	}
+	return nil
+}
+
+// maybeRecordHistory records a history generation after a sync that changed the local directory,
+// or after the first sync when history is still empty
+func (app *ConfsyncApp) maybeRecordHistory(entries map[string]FileEntry, changed bool) error {
+	if !changed {
+		generations, err := app.listHistory()
+		if err != nil || len(generations) > 0 {
+			return err
+		}
+	}
+	return app.recordHistory(entries)
+}
+
+// adminRollbackHandler lists history generations (GET), or rolls back and pins a generation (POST).
+// POST takes an optional generation parameter, defaulting to the one before the active generation,
+// or release=true to release the pin.
+func (app *ConfsyncApp) adminRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.authorizeAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var err error
+		if release, _ := strconv.ParseBool(r.FormValue("release")); release {
+			err = app.releasePin()
+		} else {
+			target := 0
+			if value := r.FormValue("generation"); value != "" {
+				if target, err = strconv.Atoi(value); err != nil || target <= 0 {
+					http.Error(w, "invalid generation", http.StatusBadRequest)
+					return
+				}
+			}
+			_, err = app.rollback(target)
+		}
+		if errors.Is(err, errNoGeneration) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			app.logger.Printf("Rollback failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	generations, err := app.listHistory()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pinned, err := app.pinnedGeneration()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"generations":       generations,
+		"pinned_generation": pinned,
+	}); err != nil {
+		app.logger.Printf("Failed to encode rollback response: %v", err)
+	}
+}
+
+// authorizeAdmin checks the bearer token of an admin request, answering 401 if it doesn't match
+func (app *ConfsyncApp) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok && subtle.ConstantTimeCompare([]byte(token), []byte(app.adminToken)) == 1 {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="confsync"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// runRollbackCommand implements "confsync rollback [generation|list|release]"
+func (app *ConfsyncApp) runRollbackCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: confsync [flags] rollback [generation|list|release]")
+	}
+
+	arg := ""
+	if len(args) == 1 {
+		arg = args[0]
+	}
+
+	switch arg {
+	case "list":
+		generations, err := app.listHistory()
+		if err != nil {
+			return err
+		}
+		pinned, err := app.pinnedGeneration()
+		if err != nil {
+			return err
+		}
+		for _, generation := range generations {
+			gen, err := app.loadGeneration(generation)
+			if err != nil {
+				return err
+			}
+			marker := ""
+			if generation == pinned {
+				marker = " (pinned)"
+			}
+			fmt.Printf("%d\t%s\t%d files%s\n", gen.Generation, gen.Created.Format(time.RFC3339), len(gen.Files), marker)
+		}
+		return nil
+	case "release":
+		if err := app.releasePin(); err != nil {
+			return err
+		}
+		app.logger.Printf("Pin released, the next sync follows the remote again")
+		return nil
+	}
+
+	target := 0
+	if arg != "" {
+		var err error
+		if target, err = strconv.Atoi(arg); err != nil || target <= 0 {
+			return fmt.Errorf("invalid generation %q", arg)
+		}
+	}
+	_, err := app.rollback(target)
+	// The command exits right away, which would drop a debounced signal and pending webhooks
+	app.flushNotifications()
+	return err
+}
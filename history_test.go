@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHistoryRollback(t *testing.T) {
+	var mu sync.Mutex
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			mtime := fmt.Sprintf("Sun, 27 Jul 2025 04:23:%02d GMT", version)
+			entries := []string{fmt.Sprintf(`{"name": "a.yaml", "type": "file", "mtime": %q, "size": 3}`, mtime)}
+			if version >= 4 {
+				entries = append(entries, `{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}`)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+		case "/a.yaml":
+			fmt.Fprintf(w, "v%d\n", version)
+		case "/b.yaml":
+			fmt.Fprint(w, "b\n")
+		}
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:      server.URL + "/",
+		LocalDir:       localDir,
+		FilePattern:    `\.yaml$`,
+		DeleteFiles:    true,
+		History:        3,
+		HistoryDir:     ".history",
+		AdminTokenFile: tokenFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	assertContent := func(name, want string) {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(localDir, name))
+		if err != nil || string(content) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", name, want, content, err)
+		}
+	}
+
+	for v := 1; v <= 4; v++ {
+		mu.Lock()
+		version = v
+		mu.Unlock()
+		if err := app.syncFiles(); err != nil {
+			t.Fatalf("Sync of version %d failed: %v", v, err)
+		}
+	}
+	// A sync without changes doesn't record a generation
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	generations, err := app.listHistory()
+	if err != nil || fmt.Sprint(generations) != "[2 3 4]" {
+		t.Fatalf("Expected generations [2 3 4], got %v (%v)", generations, err)
+	}
+
+	// Rolling back without a generation restores the previous one and pins it
+	if generation, err := app.rollback(0); err != nil || generation != 3 {
+		t.Fatalf("Expected rollback to generation 3, got %d (%v)", generation, err)
+	}
+	assertContent("a.yaml", "v3\n")
+	if _, err := os.Stat(filepath.Join(localDir, "b.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected b.yaml to be removed by the rollback, got %v", err)
+	}
+
+	// Pinned: syncing leaves the local directory alone
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Pinned sync failed: %v", err)
+	}
+	assertContent("a.yaml", "v3\n")
+	if pinned := app.getHealthStatus().Pinned; pinned != 3 {
+		t.Errorf("Expected pinned generation 3 in health status, got %d", pinned)
+	}
+
+	// The admin endpoint requires the token
+	admin := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/rollback?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		app.adminRollbackHandler(recorder, req)
+		return recorder
+	}
+
+	if code := admin("generation=2", "wrong").Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", code)
+	}
+	bare := httptest.NewRequest(http.MethodPost, "/admin/rollback?generation=2", nil)
+	bare.Header.Set("Authorization", "secret")
+	recorder := httptest.NewRecorder()
+	app.adminRollbackHandler(recorder, bare)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a token without the Bearer scheme, got %d", recorder.Code)
+	}
+	if code := admin("generation=9", "secret").Code; code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown generation, got %d", code)
+	}
+	if resp := admin("generation=2", "secret"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"pinned_generation":2`) {
+		t.Errorf("Expected rollback to generation 2, got %d: %s", resp.Code, resp.Body.String())
+	}
+	assertContent("a.yaml", "v2\n")
+
+	// Releasing the pin brings the local directory back in line with the remote
+	if resp := admin("release=true", "secret"); resp.Code != http.StatusOK {
+		t.Errorf("Expected the pin to be released, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync after release failed: %v", err)
+	}
+	assertContent("a.yaml", "v4\n")
+	assertContent("b.yaml", "b\n")
+	if pinned := app.getHealthStatus().Pinned; pinned != 0 {
+		t.Errorf("Expected no pinned generation after release, got %d", pinned)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// hookOutputLimit caps how much hook output is kept for logging and the health status
+const hookOutputLimit = 4096
+
+// syncChanges lists the files a sync added, changed and removed
+type syncChanges struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// empty reports whether nothing changed
+func (c syncChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// HookResult describes the last run of the post-sync hook
+type HookResult struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exit_code"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// describeChanges classifies the written files as added or changed by comparing them with the
+// cache from before the sync. It must be called before the cache is replaced.
+func (app *ConfsyncApp) describeChanges(written, removed []string) syncChanges {
+	changes := syncChanges{Added: []string{}, Changed: []string{}, Removed: append([]string{}, removed...)}
+	for _, filename := range written {
+		if _, existed := app.fileCache[filename]; existed {
+			changes.Changed = append(changes.Changed, filename)
+		} else {
+			changes.Added = append(changes.Added, filename)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+// afterChange notifies consumers once a sync or rollback has changed the local directory
+func (app *ConfsyncApp) afterChange(changes syncChanges) {
+	if app.config.Hook != "" {
+		app.runHook(changes)
+	}
+	if app.signaler != nil {
+		app.signaler.trigger(changes)
+	}
+	app.notify(webhookPayload{Event: eventFilesChanged, Changes: &changes})
+}
+
+// flushNotifications sends a debounced process signal right away and waits for pending webhook
+// deliveries, before a short-lived process exits
+func (app *ConfsyncApp) flushNotifications() {
+	if app.signaler != nil {
+		app.signaler.flush()
+	}
+	if app.webhooks != nil && !app.webhooks.wait(5*time.Second) {
+		app.logger.Printf("Warning: exiting with webhook deliveries pending")
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and silently drops the rest
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String returns the kept output, marking it if anything was dropped
+func (b *limitedBuffer) String() string {
+	output := strings.TrimSpace(b.buf.String())
+	if b.truncated {
+		output += "\n[output truncated]"
+	}
+	return output
+}
+
+// runHook runs the hook command with the changed files in its environment and as JSON on stdin,
+// and records the result. A failing hook marks the sync degraded when configured to.
+func (app *ConfsyncApp) runHook(changes syncChanges) {
+	payload, err := json.Marshal(struct {
+		LocalDir string `json:"local_dir"`
+		syncChanges
+	}{app.config.LocalDir, changes})
+	if err != nil {
+		app.logger.Printf("Failed to encode hook payload: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if app.config.HookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, app.config.HookTimeout)
+		defer cancel()
+	}
+
+	output := &limitedBuffer{limit: hookOutputLimit}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", app.config.Hook)
+	cmd.Dir = app.config.LocalDir
+	cmd.Env = append(os.Environ(),
+		"CONFSYNC_LOCAL_DIR="+app.config.LocalDir,
+		"CONFSYNC_ADDED_FILES="+strings.Join(changes.Added, "\n"),
+		"CONFSYNC_CHANGED_FILES="+strings.Join(changes.Changed, "\n"),
+		"CONFSYNC_REMOVED_FILES="+strings.Join(changes.Removed, "\n"),
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Don't wait forever on background processes that inherited the output pipes
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	result := &HookResult{
+		Time:     start,
+		Duration: time.Since(start),
+		ExitCode: -1,
+		Output:   output.String(),
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Error = fmt.Sprintf("timed out after %v", app.config.HookTimeout)
+	} else if err != nil {
+		result.Error = err.Error()
+	}
+
+	app.mu.Lock()
+	app.lastHook = result
+	app.mu.Unlock()
+
+	if result.Error != "" {
+		atomic.AddInt64(&app.hookFailures, 1)
+		app.logger.Printf("Hook failed after %v: %s", result.Duration.Round(time.Millisecond), result.Error)
+	} else {
+		app.logger.Printf("Hook exited with status 0 after %v", result.Duration.Round(time.Millisecond))
+	}
+	if result.Output != "" {
+		app.logger.Printf("Hook output:\n%s", result.Output)
+	}
+
+	// The sync itself succeeded, so a failing hook is reported without counting a failed sync
+	if result.Error != "" && app.config.HookDegrade {
+		app.mu.Lock()
+		app.lastError = fmt.Sprintf("hook failed: %s", result.Error)
+		app.mu.Unlock()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostSyncHook(t *testing.T) {
+	var mu sync.Mutex
+	listing := `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+		{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, listing)
+			return
+		}
+		fmt.Fprint(w, "x\n")
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	outDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:    server.URL + "/",
+		LocalDir:     localDir,
+		FilePattern:  `\.yaml$`,
+		DeleteFiles:  true,
+		PollInterval: time.Minute,
+		Hook: fmt.Sprintf(`printf '%%s' "$CONFSYNC_ADDED_FILES" > %[1]s/added; printf '%%s' "$CONFSYNC_REMOVED_FILES" > %[1]s/removed; cat > %[1]s/payload; echo reloaded`,
+			outDir),
+		HookTimeout: 10 * time.Second,
+		HookDegrade: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	readOutput := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("Expected the hook to write %s: %v", name, err)
+		}
+		return string(data)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	if added := readOutput("added"); added != "a.yaml\nb.yaml" {
+		t.Errorf("Expected both files in CONFSYNC_ADDED_FILES, got %q", added)
+	}
+	health := app.getHealthStatus()
+	if health.LastHook == nil || health.LastHook.ExitCode != 0 || health.LastHook.Output != "reloaded" {
+		t.Errorf("Expected a successful hook result with its output, got %+v", health.LastHook)
+	}
+
+	// a.yaml changes and b.yaml is removed upstream
+	mu.Lock()
+	listing = `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 05:00:00 GMT", "size": 2}]`
+	mu.Unlock()
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if removed := readOutput("removed"); removed != "b.yaml" {
+		t.Errorf("Expected b.yaml in CONFSYNC_REMOVED_FILES, got %q", removed)
+	}
+	var payload struct {
+		LocalDir string   `json:"local_dir"`
+		Added    []string `json:"added"`
+		Changed  []string `json:"changed"`
+		Removed  []string `json:"removed"`
+	}
+	if err := json.Unmarshal([]byte(readOutput("payload")), &payload); err != nil {
+		t.Fatalf("Expected a JSON payload on stdin: %v", err)
+	}
+	if payload.LocalDir != localDir || len(payload.Added) != 0 || fmt.Sprint(payload.Changed) != "[a.yaml]" || fmt.Sprint(payload.Removed) != "[b.yaml]" {
+		t.Errorf("Unexpected hook payload: %+v", payload)
+	}
+
+	// A sync without changes doesn't run the hook
+	if err := os.Remove(filepath.Join(outDir, "payload")); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Third sync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "payload")); !os.IsNotExist(err) {
+		t.Errorf("Expected the hook not to run without changes")
+	}
+
+	// A failing hook is recorded and degrades the sync
+	app.config.Hook = "echo boom; exit 3"
+	app.runHook(syncChanges{Changed: []string{"a.yaml"}})
+	health = app.getHealthStatus()
+	if health.LastHook.ExitCode != 3 || health.LastHook.Output != "boom" || health.Status != "degraded" {
+		t.Errorf("Expected a degraded status after a failing hook, got %s with %+v", health.Status, health.LastHook)
+	}
+	if health.HookFailures != 1 || health.FailedSyncs != 0 {
+		t.Errorf("Expected the hook failure to be counted without a failed sync, got %d hook failures and %d failed syncs", health.HookFailures, health.FailedSyncs)
+	}
+
+	// A hook running past its timeout is killed
+	app.config.Hook = "sleep 10"
+	app.config.HookTimeout = 100 * time.Millisecond
+	start := time.Now()
+	app.runHook(syncChanges{Changed: []string{"a.yaml"}})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hook to be killed after its timeout, took %v", elapsed)
+	}
+	if result := app.getHealthStatus().LastHook; !strings.Contains(result.Error, "timed out") {
+		t.Errorf("Expected a timeout error, got %+v", result)
+	}
+}
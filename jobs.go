@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobNamePattern restricts job names to what can be used in metric labels and query strings as is
+var jobNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// jobOnlyOptions are the options that apply to the whole process and can't be set per job
+var jobOnlyOptions = map[string]bool{"jobs": true, "health-port": true}
+
+// jobConfig is the configuration of one of several sync jobs run by a single process
+type jobConfig struct {
+	name   string
+	config Config
+}
+
+// syncJob is a named sync job
+type syncJob struct {
+	name string
+	app  *ConfsyncApp
+}
+
+// jobRunner runs several sync jobs that share a health server
+type jobRunner struct {
+	jobs         []syncJob
+	port         int
+	healthServer *http.Server
+}
+
+// loadJobConfigs reads a JSON list of jobs. Each job is an object of option names, as used by the flags,
+// and values, such as {"name": "nginx", "url": "https://...", "dir": "/etc/nginx", "delete": true}.
+// Options that a job doesn't set are taken from base.
+func loadJobConfigs(path string, base Config) ([]jobConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs file: %w", err)
+	}
+
+	var raw []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs file %s: %w", path, err)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("jobs file %s doesn't define any jobs", path)
+	}
+
+	var jobs []jobConfig
+	names := make(map[string]bool)
+	dirs := make(map[string]string)
+	for i, options := range raw {
+		name, _ := options["name"].(string)
+		if !jobNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("job %d: name must be set and consist of letters, digits, '_', '.' and '-'", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("job %s is defined more than once", name)
+		}
+		names[name] = true
+
+		config := base
+		config.Jobs = ""
+		config.HealthPort = 0
+		for option, value := range options {
+			if option == "name" {
+				continue
+			}
+			if jobOnlyOptions[option] {
+				return nil, fmt.Errorf("job %s: option %q can't be set per job", name, option)
+			}
+			if err := setConfigOption(&config, option, value); err != nil {
+				return nil, fmt.Errorf("job %s: %w", name, err)
+			}
+		}
+
+		if config.RemoteURL == "" || config.LocalDir == "" {
+			return nil, fmt.Errorf("job %s: url and dir are required", name)
+		}
+		// Jobs sharing a directory would remove each other's files and overwrite each other's state
+		dir := filepath.Clean(config.LocalDir)
+		if other, ok := dirs[dir]; ok {
+			return nil, fmt.Errorf("jobs %s and %s use the same directory %s", other, name, dir)
+		}
+		dirs[dir] = name
+
+		jobs = append(jobs, jobConfig{name: name, config: config})
+	}
+	return jobs, nil
+}
+
+// setConfigOption sets the Config field with the given flag name from a JSON value
+func setConfigOption(config *Config, option string, value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case json.Number:
+		text = v.String()
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return fmt.Errorf("invalid value for option %q: expected a string, number or boolean", option)
+	}
+
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("flag") != option {
+			continue
+		}
+
+		field := v.Field(i)
+		switch {
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			duration, err := time.ParseDuration(text)
+			if err != nil {
+				return fmt.Errorf("invalid duration for option %q: %w", option, err)
+			}
+			field.SetInt(int64(duration))
+		case field.Kind() == reflect.String:
+			field.SetString(text)
+		case field.Kind() == reflect.Int:
+			number, err := strconv.Atoi(text)
+			if err != nil {
+				return fmt.Errorf("invalid integer for option %q: %w", option, err)
+			}
+			field.SetInt(int64(number))
+		case field.Kind() == reflect.Bool:
+			enabled, err := strconv.ParseBool(text)
+			if err != nil {
+				return fmt.Errorf("invalid boolean for option %q: %w", option, err)
+			}
+			field.SetBool(enabled)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown option %q", option)
+}
+
+// newJobRunner creates the jobs defined in the jobs file of config
+func newJobRunner(config Config) (*jobRunner, error) {
+	configs, err := loadJobConfigs(config.Jobs, config)
+	if err != nil {
+		return nil, err
+	}
+
+	runner := &jobRunner{port: config.HealthPort}
+	for _, job := range configs {
+		app, err := newConfsyncAppWithLogger(job.config, newJobLogger(job.name))
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.name, err)
+		}
+		app.jobName = job.name
+		runner.jobs = append(runner.jobs, syncJob{name: job.name, app: app})
+	}
+	return runner, nil
+}
+
+// newJobLogger returns a logger that prefixes the messages of a job with its name
+func newJobLogger(name string) *log.Logger {
+	return log.New(log.Writer(), fmt.Sprintf("job=%s ", name), log.Flags()|log.Lmsgprefix)
+}
+
+// Run starts the shared health server and the synchronization loop of every job, until the process
+// receives SIGINT or SIGTERM
+func (r *jobRunner) Run() {
+	log.Printf("Starting confsync with %d jobs", len(r.jobs))
+	for _, job := range r.jobs {
+		job.app.logger.Printf("Syncing %s to %s", redactURL(job.app.config.RemoteURL), job.app.config.LocalDir)
+		if err := os.MkdirAll(job.app.config.LocalDir, 0755); err != nil {
+			log.Fatalf("Failed to create local directory %s: %v", job.app.config.LocalDir, err)
+		}
+	}
+
+	if err := r.startHealthServer(); err != nil {
+		log.Fatalf("Failed to start health server: %v", err)
+	}
+
+	sig, stop := notifyShutdown()
+	var wg sync.WaitGroup
+	for _, job := range r.jobs {
+		wg.Add(1)
+		go func(app *ConfsyncApp) {
+			defer wg.Done()
+			app.syncLoop(stop)
+		}(job.app)
+	}
+	wg.Wait()
+
+	log.Printf("Received signal %v, shutting down gracefully...", *sig)
+	for _, job := range r.jobs {
+		job.app.drain()
+	}
+	shutdownHealthServer(r.healthServer)
+	log.Printf("Shutdown complete")
+}
+
+// job returns the job with the given name
+func (r *jobRunner) job(name string) (*ConfsyncApp, bool) {
+	for _, job := range r.jobs {
+		if job.name == name {
+			return job.app, true
+		}
+	}
+	return nil, false
+}
+
+// JobsHealthStatus represents the health status of every job, and the worst of them as the overall status
+type JobsHealthStatus struct {
+	Status    string                  `json:"status"`
+	Timestamp time.Time               `json:"timestamp"`
+	Jobs      map[string]HealthStatus `json:"jobs"`
+}
+
+// healthStatusRank orders health statuses from best to worst
+var healthStatusRank = map[string]int{"healthy": 0, "degraded": 1, "unhealthy": 2}
+
+// getHealthStatus returns the health status of every job
+func (r *jobRunner) getHealthStatus() JobsHealthStatus {
+	status := JobsHealthStatus{Status: "healthy", Timestamp: time.Now(), Jobs: make(map[string]HealthStatus)}
+	for _, job := range r.jobs {
+		health := job.app.getHealthStatus()
+		status.Jobs[job.name] = health
+		if healthStatusRank[health.Status] > healthStatusRank[status.Status] {
+			status.Status = health.Status
+		}
+	}
+	return status
+}
+
+// healthHandler reports the health of every job. It fails when any job is unhealthy.
+func (r *jobRunner) healthHandler(w http.ResponseWriter, req *http.Request) {
+	health := r.getHealthStatus()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == "unhealthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Printf("Failed to encode health response: %v", err)
+	}
+}
+
+// readinessHandler reports ready once the remote of every job can be reached
+func (r *jobRunner) readinessHandler(w http.ResponseWriter, req *http.Request) {
+	var failures []string
+	for _, job := range r.jobs {
+		if err := job.app.checkReady(req.Context()); err != nil {
+			failures = append(failures, fmt.Sprintf("job %s: %v", job.name, err))
+		}
+	}
+	if len(failures) > 0 {
+		writeReadiness(w, fmt.Errorf("%s", strings.Join(failures, "; ")))
+		return
+	}
+	writeReadiness(w, nil)
+}
+
+// metricsHandler serves the metrics of every job, labeled with the job name
+func (r *jobRunner) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	var groups [][]metricFamily
+	for _, job := range r.jobs {
+		groups = append(groups, withLabel(job.app.metricFamilies(), fmt.Sprintf("job=%q", job.name)))
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	writeMetrics(w, mergeMetricFamilies(groups...))
+}
+
+// jobHandler routes a request to the handler of the job named in the job parameter. Jobs without an
+// admin token don't have admin endpoints.
+func (r *jobRunner) jobHandler(handler func(app *ConfsyncApp) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		app, ok := r.job(req.URL.Query().Get("job"))
+		if !ok || app.adminToken == "" {
+			http.Error(w, "unknown job", http.StatusNotFound)
+			return
+		}
+		handler(app)(w, req)
+	}
+}
+
+// startHealthServer starts the health check HTTP server shared by all jobs
+func (r *jobRunner) startHealthServer() error {
+	if r.port <= 0 {
+		return nil // Health server disabled
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", r.healthHandler)
+	mux.HandleFunc("/health/live", r.healthHandler)
+	mux.HandleFunc("/health/ready", r.readinessHandler)
+	mux.HandleFunc("/metrics", r.metricsHandler)
+	mux.HandleFunc("/admin/rollback", r.jobHandler(func(app *ConfsyncApp) http.HandlerFunc { return app.adminRollbackHandler }))
+	mux.HandleFunc("/sync", r.jobHandler(func(app *ConfsyncApp) http.HandlerFunc { return app.syncHandler }))
+
+	r.healthServer = &http.Server{
+		Addr:    fmt.Sprintf(":%d", r.port),
+		Handler: mux,
+	}
+
+	go func() {
+		log.Printf("Health server starting on port %d", r.port)
+		if err := r.healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server error: %v", err)
+		}
+	}()
+
+	return nil
+}
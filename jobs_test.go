@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeJobsFile writes a jobs file into a temporary directory and returns its path
+func writeJobsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write jobs file: %v", err)
+	}
+	return path
+}
+
+func TestLoadJobConfigs(t *testing.T) {
+	path := writeJobsFile(t, `[
+		{"name": "nginx", "url": "https://a.example.com/", "dir": "/etc/nginx", "interval": "30s", "delete": true, "max-depth": -1},
+		{"name": "geoip", "url": "https://b.example.com/", "dir": "/var/lib/geoip", "pattern": "\\.mmdb$"}
+	]`)
+	base := Config{FilePattern: ".*", PollInterval: time.Minute, MaxRetries: 3, HealthPort: 8080, Jobs: path}
+
+	jobs, err := loadJobConfigs(path, base)
+	if err != nil {
+		t.Fatalf("Failed to load jobs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].name != "nginx" || jobs[1].name != "geoip" {
+		t.Fatalf("Unexpected jobs: %+v", jobs)
+	}
+
+	nginx := jobs[0].config
+	if nginx.RemoteURL != "https://a.example.com/" || nginx.LocalDir != "/etc/nginx" || nginx.PollInterval != 30*time.Second ||
+		!nginx.DeleteFiles || nginx.MaxDepth != -1 || nginx.FilePattern != ".*" {
+		t.Errorf("Unexpected config for nginx: %+v", nginx)
+	}
+	geoip := jobs[1].config
+	if geoip.FilePattern != `\.mmdb$` || geoip.PollInterval != time.Minute || geoip.DeleteFiles || geoip.MaxRetries != 3 {
+		t.Errorf("Expected geoip to inherit the base options, got %+v", geoip)
+	}
+	if nginx.HealthPort != 0 || nginx.Jobs != "" {
+		t.Errorf("Expected jobs not to start their own health server, got port %d", nginx.HealthPort)
+	}
+}
+
+func TestLoadJobConfigsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"empty", `[]`, "doesn't define any jobs"},
+		{"missing name", `[{"url": "https://a/", "dir": "/a"}]`, "name must be set"},
+		{"duplicate name", `[{"name": "a", "url": "https://a/", "dir": "/a"}, {"name": "a", "url": "https://a/", "dir": "/b"}]`, "more than once"},
+		{"same directory", `[{"name": "a", "url": "https://a/", "dir": "/a"}, {"name": "b", "url": "https://b/", "dir": "/a/"}]`, "same directory"},
+		{"missing dir", `[{"name": "a", "url": "https://a/"}]`, "url and dir are required"},
+		{"unknown option", `[{"name": "a", "url": "https://a/", "dir": "/a", "colour": "red"}]`, `unknown option "colour"`},
+		{"process option", `[{"name": "a", "url": "https://a/", "dir": "/a", "health-port": 9090}]`, "can't be set per job"},
+		{"invalid duration", `[{"name": "a", "url": "https://a/", "dir": "/a", "interval": 30}]`, "invalid duration"},
+		{"invalid value", `[{"name": "a", "url": "https://a/", "dir": "/a", "pattern": ["x"]}]`, "expected a string, number or boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadJobConfigs(writeJobsFile(t, tt.content), Config{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestJobRunnerEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`))
+			return
+		}
+		w.Write([]byte("a\n"))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	jobsFile, _ := json.Marshal([]map[string]interface{}{
+		{"name": "one", "url": server.URL + "/", "dir": t.TempDir(), "admin-token-file": tokenFile},
+		{"name": "two", "url": server.URL + "/", "dir": t.TempDir(), "pattern": `\.json$`},
+	})
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	runner, err := newJobRunner(Config{Jobs: writeJobsFile(t, string(jobsFile)), FilePattern: ".*", PollInterval: time.Minute, HealthPort: 8080})
+	if err != nil {
+		t.Fatalf("Failed to create jobs: %v", err)
+	}
+	for _, job := range runner.jobs {
+		if err := job.app.syncFiles(); err != nil {
+			t.Fatalf("Sync of job %s failed: %v", job.name, err)
+		}
+	}
+	if !strings.Contains(logs.String(), "job=one Sync complete: downloaded 1,") {
+		t.Errorf("Expected the log messages of a job to be prefixed with its name, got:\n%s", logs.String())
+	}
+
+	recorder := httptest.NewRecorder()
+	runner.healthHandler(recorder, httptest.NewRequest("GET", "/health", nil))
+	var health JobsHealthStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health: %v", err)
+	}
+	if recorder.Code != http.StatusOK || health.Status != "healthy" || len(health.Jobs) != 2 {
+		t.Fatalf("Unexpected health response %d: %+v", recorder.Code, health)
+	}
+	if health.Jobs["one"].SyncedFiles != 1 || health.Jobs["two"].SyncedFiles != 0 {
+		t.Errorf("Expected each job to report its own synced files, got %+v", health.Jobs)
+	}
+
+	recorder = httptest.NewRecorder()
+	runner.metricsHandler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := recorder.Body.String()
+	for _, want := range []string{
+		"confsync_synced_files_total{job=\"one\"} 1\nconfsync_synced_files_total{job=\"two\"} 0\n",
+		`confsync_watching{job="two"} 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, metrics)
+		}
+	}
+	if count := strings.Count(metrics, "# TYPE confsync_synced_files_total counter"); count != 1 {
+		t.Errorf("Expected a single TYPE line per metric, got %d", count)
+	}
+
+	// Admin endpoints are routed by job, and only exist for jobs with a token
+	for _, tt := range []struct {
+		job  string
+		code int
+	}{{"one", http.StatusOK}, {"two", http.StatusNotFound}, {"three", http.StatusNotFound}} {
+		req := httptest.NewRequest("POST", "/sync?wait=true&job="+tt.job, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		runner.jobHandler(func(app *ConfsyncApp) http.HandlerFunc { return app.syncHandler })(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("Expected %d for job %s, got %d", tt.code, tt.job, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Supported directory listing formats
+const (
+	listingFormatAuto   = "auto"
+	listingFormatJSON   = "json"
+	listingFormatHTML   = "html"
+	listingFormatXML    = "xml"
+	listingFormatCaddy  = "caddy"
+	listingFormatCustom = "custom"
+)
+
+// autoListingAccept is the Accept header sent when the listing format is auto-detected
+const autoListingAccept = "application/json, application/xml;q=0.9, text/html;q=0.8, */*;q=0.5"
+
+// ListingParser converts a directory listing response body into file entries.
+// Entry names must be single path segments; Type is "file" or "directory".
+type ListingParser interface {
+	// Accept returns the media types to request from the server
+	Accept() string
+	// Parse converts a listing response body into file entries
+	Parse(body []byte) ([]FileEntry, error)
+}
+
+// newListingParser returns the parser for the given listing format, or nil when
+// the format should be detected from each response
+func newListingParser(format, mapping string) (ListingParser, error) {
+	switch format {
+	case "", listingFormatAuto:
+		return nil, nil
+	case listingFormatJSON:
+		return nginxJSONParser{}, nil
+	case listingFormatHTML:
+		return htmlListingParser{}, nil
+	case listingFormatXML:
+		return nginxXMLParser{}, nil
+	case listingFormatCaddy:
+		return caddyListingParser{}, nil
+	case listingFormatCustom:
+		return newJSONMappingParser(mapping)
+	default:
+		return nil, fmt.Errorf("invalid listing format %q: must be one of auto, json, html, xml, caddy, custom", format)
+	}
+}
+
+// detectListingParser picks a parser from the response Content-Type, falling back to sniffing the body
+func detectListingParser(contentType string, body []byte) ListingParser {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"),
+		len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{'):
+		if looksLikeCaddyListing(trimmed) {
+			return caddyListingParser{}
+		}
+		return nginxJSONParser{}
+	case mediaType == "application/xml" || mediaType == "text/xml",
+		bytes.HasPrefix(trimmed, []byte("<?xml")) && bytes.Contains(trimmed, []byte("<list>")):
+		return nginxXMLParser{}
+	default:
+		return htmlListingParser{}
+	}
+}
+
+// nginxJSONParser parses an nginx "autoindex_format json" listing
+type nginxJSONParser struct{}
+
+// Accept returns the media types to request from the server
+func (nginxJSONParser) Accept() string {
+	return "application/json"
+}
+
+// Parse converts an nginx JSON listing into file entries
+func (nginxJSONParser) Parse(body []byte) ([]FileEntry, error) {
+	var entries []FileEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return entries, nil
+}
+
+// nginxXMLParser parses an nginx "autoindex_format xml" listing
+type nginxXMLParser struct{}
+
+// Accept returns the media types to request from the server
+func (nginxXMLParser) Accept() string {
+	return "application/xml, text/xml"
+}
+
+// Parse converts an nginx XML listing into file entries
+func (nginxXMLParser) Parse(body []byte) ([]FileEntry, error) {
+	var listing struct {
+		Items []struct {
+			XMLName xml.Name
+			Name    string `xml:",chardata"`
+			MTime   string `xml:"mtime,attr"`
+			Size    int64  `xml:"size,attr"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse XML response: %w", err)
+	}
+
+	entries := make([]FileEntry, 0, len(listing.Items))
+	for _, item := range listing.Items {
+		entries = append(entries, FileEntry{
+			Name:  item.Name,
+			Type:  item.XMLName.Local,
+			MTime: item.MTime,
+			Size:  item.Size,
+		})
+	}
+	return entries, nil
+}
+
+// caddyListingParser parses the JSON listing served by Caddy's file_server browse
+type caddyListingParser struct{}
+
+// caddyEntry is a single item of a Caddy browse JSON listing
+type caddyEntry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mod_time"`
+	IsDir   bool   `json:"is_dir"`
+}
+
+// Accept returns the media types to request from the server
+func (caddyListingParser) Accept() string {
+	return "application/json"
+}
+
+// Parse converts a Caddy browse listing into file entries
+func (caddyListingParser) Parse(body []byte) ([]FileEntry, error) {
+	var items []caddyEntry
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	entries := make([]FileEntry, 0, len(items))
+	for _, item := range items {
+		entry := FileEntry{
+			Name:  strings.TrimSuffix(item.Name, "/"),
+			Type:  "file",
+			MTime: item.ModTime,
+			Size:  item.Size,
+		}
+		if item.IsDir {
+			entry.Type = "directory"
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// looksLikeCaddyListing reports whether a JSON listing uses Caddy's field names
+func looksLikeCaddyListing(body []byte) bool {
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil || len(items) == 0 {
+		return false
+	}
+	_, hasIsDir := items[0]["is_dir"]
+	_, hasModTime := items[0]["mod_time"]
+	return hasIsDir || hasModTime
+}
+
+// fetchFileInfo issues a HEAD request to learn a file's modification time and size
+func (app *ConfsyncApp) fetchFileInfo(fileURL string) (string, int64, error) {
+	atomic.AddInt64(&app.totalReqs, 1)
+
+	req, err := http.NewRequest("HEAD", fileURL, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", app.config.UserAgent)
+
+	resp, err := app.listingClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch file info: %w", err)
+	}
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		return "", 0, fmt.Errorf("failed to close response body: %w", closeErr)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("server returned status %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	return resp.Header.Get("Last-Modified"), resp.ContentLength, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// htmlLinkRegex matches anchor tags and captures the href value
+	htmlLinkRegex = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']*)["'][^>]*>`)
+	// htmlTagRegex matches any HTML tag, used to strip markup from the text following a link
+	htmlTagRegex = regexp.MustCompile(`(?s)<[^>]*>`)
+	// htmlRowEndRegex matches the end of a table row or preformatted line
+	htmlRowEndRegex = regexp.MustCompile(`(?i)</tr>|\n`)
+	// htmlSizeRegex matches an exact byte count or a human-readable size following the date column
+	htmlSizeRegex = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)([KMGTP]i?B?)?\b`)
+)
+
+// htmlDateLayouts lists the date formats used by common autoindex implementations
+var htmlDateLayouts = []struct {
+	pattern *regexp.Regexp
+	layouts []string
+}{
+	// nginx and Apache <pre> listings: 27-Jul-2025 04:23
+	{regexp.MustCompile(`\d{1,2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}(?::\d{2})?`), []string{"2-Jan-2006 15:04:05", "2-Jan-2006 15:04"}},
+	// Apache table listings: 2025-07-27 04:23
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}(?::\d{2})?`), []string{"2006-01-02 15:04:05", "2006-01-02 15:04"}},
+	// lighttpd listings: 2025-Jul-27 04:23:20
+	{regexp.MustCompile(`\d{4}-[A-Za-z]{3}-\d{2} \d{2}:\d{2}(?::\d{2})?`), []string{"2006-Jan-02 15:04:05", "2006-Jan-02 15:04"}},
+}
+
+// htmlListingParser parses HTML autoindex pages as served by Apache httpd, nginx,
+// lighttpd or Go's http.FileServer. Modification times and exact sizes are filled in
+// when the page exposes them; otherwise MTime is left empty and Size is set to -1.
+type htmlListingParser struct{}
+
+// Accept returns the media types to request from the server
+func (htmlListingParser) Accept() string {
+	return "text/html"
+}
+
+// Parse converts an HTML autoindex page into file entries
+func (htmlListingParser) Parse(body []byte) ([]FileEntry, error) {
+	page := string(body)
+	matches := htmlLinkRegex.FindAllStringSubmatchIndex(page, -1)
+
+	var entries []FileEntry
+	index := make(map[string]int)
+	parentLink := false
+
+	for i, match := range matches {
+		href := html.UnescapeString(page[match[2]:match[3]])
+
+		// The metadata columns follow the link, up to the next link or the end of the row
+		textEnd := len(page)
+		if i+1 < len(matches) {
+			textEnd = matches[i+1][0]
+		}
+		text := page[match[1]:textEnd]
+		label := ""
+		if closeIdx := strings.Index(strings.ToLower(text), "</a>"); closeIdx != -1 {
+			label = text[:closeIdx]
+			text = text[closeIdx+len("</a>"):]
+		}
+
+		if isParentLink(href, label) {
+			parentLink = true
+			continue
+		}
+		name, isDir, ok := hrefToEntryName(href)
+		if !ok {
+			continue
+		}
+		if rowEnd := htmlRowEndRegex.FindStringIndex(text); rowEnd != nil {
+			text = text[:rowEnd[0]]
+		}
+		mtime, size := parseHTMLMetadata(html.UnescapeString(htmlTagRegex.ReplaceAllString(text, " ")))
+
+		entry := FileEntry{Name: name, Type: "file", MTime: mtime, Size: size}
+		if isDir {
+			entry.Type = "directory"
+			entry.Size = 0
+		}
+
+		// Icon links repeat the href; keep the first entry and fill in any metadata found later
+		if existing, seen := index[name]; seen {
+			if entries[existing].MTime == "" {
+				entries[existing].MTime = entry.MTime
+			}
+			if entries[existing].Size < 0 {
+				entries[existing].Size = entry.Size
+			}
+			continue
+		}
+		index[name] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	// Other pages, such as a login or maintenance page, must not be taken for an empty directory
+	if len(entries) == 0 && !parentLink {
+		return nil, fmt.Errorf("HTML page is not a directory listing: no entries and no parent directory link")
+	}
+	return entries, nil
+}
+
+// isParentLink reports whether an autoindex link leads to the parent directory, as "../" or,
+// in Apache listings, an absolute link labeled "Parent Directory"
+func isParentLink(href, label string) bool {
+	if href = strings.TrimPrefix(href, "./"); href == ".." || href == "../" {
+		return true
+	}
+	label = strings.TrimSpace(html.UnescapeString(htmlTagRegex.ReplaceAllString(label, "")))
+	return strings.EqualFold(label, "Parent Directory")
+}
+
+// hrefToEntryName converts a relative autoindex link into an entry name, rejecting
+// sort links, parent directory links and links that leave the listed directory
+func hrefToEntryName(href string) (name string, isDir bool, ok bool) {
+	if parsed, err := url.Parse(href); err != nil || parsed.IsAbs() || parsed.RawQuery != "" {
+		return "", false, false
+	}
+	// Go's http.FileServer prefixes names containing a colon with "./" so they aren't read as a scheme
+	href = strings.TrimPrefix(href, "./")
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "/") {
+		return "", false, false
+	}
+
+	isDir = strings.HasSuffix(href, "/")
+	unescaped, err := url.PathUnescape(strings.TrimSuffix(href, "/"))
+	if err != nil || !isSafeEntryName(unescaped) {
+		return "", false, false
+	}
+	return unescaped, isDir, true
+}
+
+// parseHTMLMetadata extracts the modification time and exact size from the text following an autoindex link.
+// Human-readable sizes such as "1.2K" are too coarse for change detection and are reported as unknown (-1).
+func parseHTMLMetadata(text string) (string, int64) {
+	mtime := ""
+	size := int64(-1)
+
+	rest := text
+	for _, date := range htmlDateLayouts {
+		loc := date.pattern.FindStringIndex(text)
+		if loc == nil {
+			continue
+		}
+		for _, layout := range date.layouts {
+			if t, err := time.ParseInLocation(layout, text[loc[0]:loc[1]], time.UTC); err == nil {
+				mtime = t.UTC().Format(http.TimeFormat)
+				break
+			}
+		}
+		rest = text[loc[1]:]
+		break
+	}
+
+	if m := htmlSizeRegex.FindStringSubmatch(rest); m != nil && m[2] == "" {
+		if n, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	return mtime, size
+}
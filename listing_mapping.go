@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonPathStep is a single object key or array index lookup
+type jsonPathStep struct {
+	key   string
+	index int
+}
+
+// jsonPath is a compiled JSONPath-like selector such as "$.data.items" or "meta.files[0].name"
+type jsonPath []jsonPathStep
+
+// compileJSONPath parses a dotted selector with optional leading "$" and bracketed array indexes
+func compileJSONPath(selector string) (jsonPath, error) {
+	selector = strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	selector = strings.TrimPrefix(selector, ".")
+
+	var path jsonPath
+	if selector == "" {
+		return path, nil
+	}
+
+	for _, part := range strings.Split(selector, ".") {
+		key := part
+		indexes := ""
+		if i := strings.Index(part, "["); i != -1 {
+			key, indexes = part[:i], part[i:]
+		}
+		if key == "" && indexes == "" {
+			return nil, fmt.Errorf("empty segment in selector %q", selector)
+		}
+		if key != "" {
+			path = append(path, jsonPathStep{key: key, index: -1})
+		}
+
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end == -1 {
+				return nil, fmt.Errorf("malformed index in selector %q", selector)
+			}
+			index, err := strconv.Atoi(indexes[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q in selector %q", indexes[1:end], selector)
+			}
+			path = append(path, jsonPathStep{index: index})
+			indexes = indexes[end+1:]
+		}
+	}
+
+	return path, nil
+}
+
+// lookup walks the path through a decoded JSON value
+func (path jsonPath) lookup(value interface{}) (interface{}, bool) {
+	for _, step := range path {
+		if step.key != "" {
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = obj[step.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		arr, ok := value.([]interface{})
+		if !ok || step.index >= len(arr) {
+			return nil, false
+		}
+		value = arr[step.index]
+	}
+	return value, true
+}
+
+// jsonMappingParser parses listings in an arbitrary JSON shape using user-defined field selectors
+type jsonMappingParser struct {
+	root  jsonPath
+	name  jsonPath
+	typ   jsonPath
+	mtime jsonPath
+	size  jsonPath
+}
+
+// newJSONMappingParser builds a parser from a mapping such as
+// "root=$.data.items,name=path,type=kind,mtime=updated_at,size=bytes".
+// Only name is required; root defaults to the document itself.
+func newJSONMappingParser(mapping string) (*jsonMappingParser, error) {
+	if strings.TrimSpace(mapping) == "" {
+		return nil, fmt.Errorf("custom listing format requires a listing mapping")
+	}
+
+	parser := &jsonMappingParser{}
+	fields := map[string]*jsonPath{
+		"root":  &parser.root,
+		"name":  &parser.name,
+		"type":  &parser.typ,
+		"mtime": &parser.mtime,
+		"size":  &parser.size,
+	}
+
+	for _, pair := range strings.Split(mapping, ",") {
+		key, selector, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid listing mapping entry %q: expected field=selector", pair)
+		}
+		target, known := fields[strings.TrimSpace(key)]
+		if !known {
+			return nil, fmt.Errorf("unknown listing mapping field %q", strings.TrimSpace(key))
+		}
+		path, err := compileJSONPath(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing mapping for %s: %w", strings.TrimSpace(key), err)
+		}
+		*target = path
+	}
+
+	if len(parser.name) == 0 {
+		return nil, fmt.Errorf("listing mapping must define a name selector")
+	}
+
+	return parser, nil
+}
+
+// Accept returns the media types to request from the server
+func (p *jsonMappingParser) Accept() string {
+	return "application/json"
+}
+
+// Parse converts a JSON document into file entries using the configured selectors
+func (p *jsonMappingParser) Parse(body []byte) ([]FileEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	root, ok := p.root.lookup(document)
+	if !ok {
+		return nil, fmt.Errorf("listing root not found in response")
+	}
+	items, ok := root.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("listing root is not an array")
+	}
+
+	entries := make([]FileEntry, 0, len(items))
+	for i, item := range items {
+		nameValue, _ := p.name.lookup(item)
+		name, ok := nameValue.(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("listing item %d has no string name", i)
+		}
+
+		entry := FileEntry{Name: name, Type: "file", Size: -1}
+		if strings.HasSuffix(name, "/") {
+			entry.Name = strings.TrimSuffix(name, "/")
+			entry.Type = "directory"
+		}
+
+		if len(p.typ) > 0 {
+			if typeValue, found := p.typ.lookup(item); found {
+				entry.Type = mappedEntryType(typeValue)
+			}
+		}
+
+		if len(p.mtime) > 0 {
+			if mtimeValue, found := p.mtime.lookup(item); found {
+				entry.MTime = mappedMTime(mtimeValue)
+			}
+		}
+
+		if len(p.size) > 0 {
+			if sizeValue, found := p.size.lookup(item); found {
+				entry.Size = mappedSize(sizeValue)
+			}
+		}
+
+		if entry.Type == "directory" {
+			entry.Size = 0
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// mappedEntryType interprets a type field: booleans are "is directory" flags, strings are type names
+func mappedEntryType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "directory"
+		}
+		return "file"
+	case string:
+		switch strings.ToLower(v) {
+		case "directory", "dir", "folder", "tree":
+			return "directory"
+		case "file", "blob", "regular":
+			return "file"
+		}
+	}
+	return "other"
+}
+
+// mappedMTime converts a modification time field to a string; numbers are treated as Unix seconds
+func mappedMTime(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		if seconds, err := v.Int64(); err == nil {
+			return time.Unix(seconds, 0).UTC().Format(http.TimeFormat)
+		}
+		return v.String()
+	}
+	return ""
+}
+
+// mappedSize converts a size field given as a number or numeric string, returning -1 when unknown
+func mappedSize(value interface{}) int64 {
+	var raw string
+	switch v := value.(type) {
+	case json.Number:
+		raw = v.String()
+	case string:
+		raw = v
+	default:
+		return -1
+	}
+
+	size, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHTMLListing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		page     string
+		expected []FileEntry
+	}{
+		{
+			name: "nginx",
+			page: `<html><head><title>Index of /config/</title></head><body><h1>Index of /config/</h1><hr><pre><a href="../">../</a>
+<a href="ns/">ns/</a>                                                27-Jul-2025 04:23       -
+<a href="config.yaml">config.yaml</a>                                        27-Jul-2025 04:23     167
+</pre><hr></body></html>`,
+			expected: []FileEntry{
+				{Name: "ns", Type: "directory", MTime: "Sun, 27 Jul 2025 04:23:00 GMT", Size: 0},
+				{Name: "config.yaml", Type: "file", MTime: "Sun, 27 Jul 2025 04:23:00 GMT", Size: 167},
+			},
+		},
+		{
+			name: "apache table",
+			page: `<table>
+<tr><th valign="top"><img src="/icons/blank.gif" alt="[ICO]"></th><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th></tr>
+<tr><td valign="top"><img src="/icons/back.gif" alt="[PARENTDIR]"></td><td><a href="/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td></tr>
+<tr><td valign="top"><img src="/icons/text.gif" alt="[TXT]"></td><td><a href="my%20app.yaml">my app.yaml</a></td><td align="right">2025-07-27 04:23  </td><td align="right">1.2K</td></tr>
+<tr><td valign="top"><img src="/icons/text.gif" alt="[TXT]"></td><td><a href="small.yaml">small.yaml</a></td><td align="right">2025-07-27 04:23  </td><td align="right">266 </td></tr>
+</table>`,
+			expected: []FileEntry{
+				{Name: "my app.yaml", Type: "file", MTime: "Sun, 27 Jul 2025 04:23:00 GMT", Size: -1},
+				{Name: "small.yaml", Type: "file", MTime: "Sun, 27 Jul 2025 04:23:00 GMT", Size: 266},
+			},
+		},
+		{
+			name: "lighttpd",
+			page: `<tbody>
+<tr class="d"><td class="n"><a href="../">..</a>/</td><td class="m">&nbsp;</td><td class="s">- &nbsp;</td><td class="t">Directory</td></tr>
+<tr><td class="n"><a href="config.yaml">config.yaml</a></td><td class="m">2025-Jul-27 04:23:20</td><td class="s">0.1K</td><td class="t">application/yaml</td></tr>
+</tbody>`,
+			expected: []FileEntry{
+				{Name: "config.yaml", Type: "file", MTime: "Sun, 27 Jul 2025 04:23:20 GMT", Size: -1},
+			},
+		},
+		{
+			name: "go file server",
+			page: `<pre>
+<a href="config.yaml">config.yaml</a>
+<a href="./a:b.yaml">a:b.yaml</a>
+<a href="sub/">sub/</a>
+</pre>`,
+			expected: []FileEntry{
+				{Name: "config.yaml", Type: "file", MTime: "", Size: -1},
+				{Name: "a:b.yaml", Type: "file", MTime: "", Size: -1},
+				{Name: "sub", Type: "directory", MTime: "", Size: 0},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := htmlListingParser{}.Parse([]byte(tc.page))
+			if err != nil {
+				t.Fatalf("Failed to parse listing: %v", err)
+			}
+
+			if len(entries) != len(tc.expected) {
+				t.Fatalf("Expected %d entries, got %d: %+v", len(tc.expected), len(entries), entries)
+			}
+
+			for i, expected := range tc.expected {
+				if entries[i] != expected {
+					t.Errorf("Entry %d: expected %+v, got %+v", i, expected, entries[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseHTMLNonListing(t *testing.T) {
+	// An empty directory still links to its parent
+	entries, err := htmlListingParser{}.Parse([]byte(`<html><body><h1>Index of /config/</h1><hr><pre><a href="../">../</a>
+</pre><hr></body></html>`))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty listing, got %+v, %v", entries, err)
+	}
+
+	for _, page := range []string{
+		`<html><body><h1>Down for maintenance</h1></body></html>`,
+		`<html><body><a href="https://sso.example.com/login">Log in</a></body></html>`,
+	} {
+		if _, err := (htmlListingParser{}).Parse([]byte(page)); err == nil {
+			t.Errorf("Expected an error for a page that isn't a listing: %s", page)
+		}
+	}
+}
+
+func TestDetectListingParser(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+		expected    ListingParser
+	}{
+		{"application/json", "[]", nginxJSONParser{}},
+		{"application/json", `[{"name": "a", "is_dir": false, "mod_time": "2025-07-27T04:23:20Z"}]`, caddyListingParser{}},
+		{"text/html; charset=utf-8", "<html>", htmlListingParser{}},
+		{"text/plain", " [{\"name\": \"a\"}]", nginxJSONParser{}},
+		{"", `<?xml version="1.0"?><list></list>`, nginxXMLParser{}},
+		{"", "<pre></pre>", htmlListingParser{}},
+	}
+
+	for _, tc := range testCases {
+		if parser := detectListingParser(tc.contentType, []byte(tc.body)); parser != tc.expected {
+			t.Errorf("detectListingParser(%q, %q): expected %T, got %T", tc.contentType, tc.body, tc.expected, parser)
+		}
+	}
+}
+
+func TestNginxXMLParser(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<list>
+<directory mtime="2025-07-27T04:23:20Z">ns</directory>
+<file mtime="2025-07-27T04:23:20Z" size="167">config.yaml</file>
+</list>`
+
+	entries, err := nginxXMLParser{}.Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Failed to parse listing: %v", err)
+	}
+
+	expected := []FileEntry{
+		{Name: "ns", Type: "directory", MTime: "2025-07-27T04:23:20Z"},
+		{Name: "config.yaml", Type: "file", MTime: "2025-07-27T04:23:20Z", Size: 167},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, entries)
+	}
+}
+
+func TestCaddyListingParser(t *testing.T) {
+	body := `[
+		{"name": "ns/", "size": 4096, "url": "./ns/", "mod_time": "2025-07-27T04:23:20.5Z", "mode": 2147484141, "is_dir": true, "is_symlink": false},
+		{"name": "config.yaml", "size": 167, "url": "./config.yaml", "mod_time": "2025-07-27T04:23:20.5Z", "mode": 420, "is_dir": false, "is_symlink": false}
+	]`
+
+	entries, err := caddyListingParser{}.Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Failed to parse listing: %v", err)
+	}
+
+	expected := []FileEntry{
+		{Name: "ns", Type: "directory", MTime: "2025-07-27T04:23:20.5Z", Size: 4096},
+		{Name: "config.yaml", Type: "file", MTime: "2025-07-27T04:23:20.5Z", Size: 167},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, entries)
+	}
+}
+
+func TestJSONMappingParser(t *testing.T) {
+	parser, err := newJSONMappingParser("root=$.data.items,name=path,type=meta.kind,mtime=updated,size=bytes")
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	body := `{"data": {"items": [
+		{"path": "config.yaml", "meta": {"kind": "blob"}, "updated": 1753590200, "bytes": 167},
+		{"path": "ns", "meta": {"kind": "tree"}, "updated": "2025-07-27T04:23:20Z"},
+		{"path": "link", "meta": {"kind": "symlink"}, "bytes": "12"}
+	]}}`
+
+	entries, err := parser.Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Failed to parse listing: %v", err)
+	}
+
+	expected := []FileEntry{
+		{Name: "config.yaml", Type: "file", MTime: "Sun, 27 Jul 2025 04:23:20 GMT", Size: 167},
+		{Name: "ns", Type: "directory", MTime: "2025-07-27T04:23:20Z", Size: 0},
+		{Name: "link", Type: "other", Size: 12},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, entries)
+	}
+
+	invalidMappings := []string{
+		"",
+		"root=items",
+		"name=items[x]",
+		"name=path,owner=user",
+		"name",
+	}
+	for _, mapping := range invalidMappings {
+		if _, err := newJSONMappingParser(mapping); err == nil {
+			t.Errorf("Expected mapping %q to be rejected", mapping)
+		}
+	}
+}
+
+func TestJSONPathLookup(t *testing.T) {
+	document := map[string]interface{}{
+		"files": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+
+	testCases := []struct {
+		selector string
+		expected interface{}
+		found    bool
+	}{
+		{"$.files[1].name", "b", true},
+		{"files[0].name", "a", true},
+		{"files[2].name", nil, false},
+		{"files.name", nil, false},
+	}
+
+	for _, tc := range testCases {
+		path, err := compileJSONPath(tc.selector)
+		if err != nil {
+			t.Fatalf("Failed to compile %q: %v", tc.selector, err)
+		}
+		value, found := path.lookup(document)
+		if found != tc.found || (found && value != tc.expected) {
+			t.Errorf("lookup(%q): expected (%v, %v), got (%v, %v)", tc.selector, tc.expected, tc.found, value, found)
+		}
+	}
+}
+
+func TestSyncFromHTMLFileServer(t *testing.T) {
+	remoteDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(remoteDir, "config.yaml"), []byte("key: value\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(remoteDir)))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	cached, ok := app.fileCache["config.yaml"]
+	if !ok {
+		t.Fatal("Expected config.yaml to be cached")
+	}
+	if cached.Size != 11 || cached.MTime == "" {
+		t.Errorf("Expected HEAD fallback to fill in size and mtime, got %+v", cached)
+	}
+
+	content, err := os.ReadFile(filepath.Join(localDir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Expected config.yaml to be synced: %v", err)
+	}
+	if string(content) != "key: value\n" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+}
+
+func TestSyncKeepsFilesOnErrorPage(t *testing.T) {
+	var maintenance int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&maintenance) == 1 {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><h1>Down for maintenance</h1></body></html>`))
+			return
+		}
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`))
+			return
+		}
+		w.Write([]byte("a\n"))
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: ".*",
+		DeleteFiles: true,
+		RetryDelay:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	atomic.StoreInt32(&maintenance, 1)
+	if err := app.syncFiles(); err == nil || !strings.Contains(err.Error(), "not a directory listing") {
+		t.Errorf("Expected the sync to fail on a maintenance page, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "a.yaml")); err != nil {
+		t.Errorf("Expected a.yaml to be kept: %v", err)
+	}
+	if health := app.getHealthStatus(); health.FailedSyncs != 1 || health.LastError == "" {
+		t.Errorf("Expected a failed sync, got %d failed syncs and last error %q", health.FailedSyncs, health.LastError)
+	}
+}
+
+func TestSyncRejectsEmptyHTMLListingInAutoMode(t *testing.T) {
+	emptyListing := `<html><body><h1>Index of /</h1><hr><pre><a href="../">../</a>
+</pre><hr></body></html>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(emptyListing))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		format  string
+		wantErr bool
+	}{{listingFormatAuto, true}, {listingFormatHTML, false}} {
+		app, err := NewConfsyncApp(Config{RemoteURL: server.URL + "/", LocalDir: t.TempDir(), FilePattern: ".*", ListingFormat: tc.format})
+		if err != nil {
+			t.Fatalf("Failed to create app: %v", err)
+		}
+		if err := app.syncFiles(); (err != nil) != tc.wantErr {
+			t.Errorf("Format %s: expected error %t, got %v", tc.format, tc.wantErr, err)
+		}
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -23,67 +28,252 @@ import (
 
 // FileEntry represents a file entry from the remote directory listing
 type FileEntry struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	MTime string `json:"mtime"`
-	Size  int64  `json:"size"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	MTime  string `json:"mtime"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Source is the overlay source the entry was taken from, 0 for the remote URL. It is never
+	// taken from remote data, and is persisted separately in the state file and history.
+	Source int `json:"-"`
 }
 
 // Config holds the application configuration
 type Config struct {
-	RemoteURL       string        `flag:"url" env:"CONFSYNC_URL" default:"" description:"Remote server URL providing directory listing"`
-	LocalDir        string        `flag:"dir" env:"CONFSYNC_LOCAL_DIR" default:"" description:"Local directory to sync files to"`
-	FilePattern     string        `flag:"pattern" env:"CONFSYNC_FILE_PATTERN" default:".*" description:"Regex pattern to match files"`
-	PollInterval    time.Duration `flag:"interval" env:"CONFSYNC_POLL_INTERVAL" default:"60s" description:"Polling interval"`
-	UserAgent       string        `flag:"user-agent" env:"CONFSYNC_USER_AGENT" default:"confsync/1.0" description:"HTTP User-Agent header"`
-	ConnectTimeout  time.Duration `flag:"connect-timeout" env:"CONFSYNC_CONNECT_TIMEOUT" default:"10s" description:"HTTP connection and listing timeout"`
-	DownloadTimeout time.Duration `flag:"download-timeout" env:"CONFSYNC_DOWNLOAD_TIMEOUT" default:"0s" description:"Maximum download time per file (0 = unlimited)"`
-	MaxRetries      int           `flag:"max-retries" env:"CONFSYNC_MAX_RETRIES" default:"3" description:"Maximum number of retries for failed requests"`
-	RetryDelay      time.Duration `flag:"retry-delay" env:"CONFSYNC_RETRY_DELAY" default:"5s" description:"Base delay for exponential backoff retries"`
-	Verbose         bool          `flag:"verbose" env:"CONFSYNC_VERBOSE" default:"false" description:"Enable verbose logging"`
-	HealthPort      int           `flag:"health-port" env:"CONFSYNC_HEALTH_PORT" default:"8080" description:"Port for health check endpoint (0 to disable)"`
-	DeleteFiles     bool          `flag:"delete" env:"CONFSYNC_DELETE" default:"false" description:"Enable automatic deletion of local files not on remote server"`
+	RemoteURL         string        `flag:"url" env:"CONFSYNC_URL" default:"" description:"Remote server URL providing directory listing"`
+	SourceType        string        `flag:"source" env:"CONFSYNC_SOURCE" default:"http" description:"Remote source type (http, s3)"`
+	LocalDir          string        `flag:"dir" env:"CONFSYNC_LOCAL_DIR" default:"" description:"Local directory to sync files to"`
+	FilePattern       string        `flag:"pattern" env:"CONFSYNC_FILE_PATTERN" default:".*" description:"Regex pattern to match files"`
+	PollInterval      time.Duration `flag:"interval" env:"CONFSYNC_POLL_INTERVAL" default:"60s" description:"Polling interval"`
+	UserAgent         string        `flag:"user-agent" env:"CONFSYNC_USER_AGENT" default:"confsync/1.0" description:"HTTP User-Agent header"`
+	ConnectTimeout    time.Duration `flag:"connect-timeout" env:"CONFSYNC_CONNECT_TIMEOUT" default:"10s" description:"HTTP connection and listing timeout"`
+	DownloadTimeout   time.Duration `flag:"download-timeout" env:"CONFSYNC_DOWNLOAD_TIMEOUT" default:"0s" description:"Maximum download time per file (0 = unlimited)"`
+	MaxRetries        int           `flag:"max-retries" env:"CONFSYNC_MAX_RETRIES" default:"3" description:"Maximum number of retries for failed requests"`
+	RetryDelay        time.Duration `flag:"retry-delay" env:"CONFSYNC_RETRY_DELAY" default:"5s" description:"Base delay for exponential backoff retries"`
+	Verbose           bool          `flag:"verbose" env:"CONFSYNC_VERBOSE" default:"false" description:"Enable verbose logging"`
+	HealthPort        int           `flag:"health-port" env:"CONFSYNC_HEALTH_PORT" default:"8080" description:"Port for health check endpoint (0 to disable)"`
+	DeleteFiles       bool          `flag:"delete" env:"CONFSYNC_DELETE" default:"false" description:"Enable automatic deletion of local files not on remote server"`
+	MaxDepth          int           `flag:"max-depth" env:"CONFSYNC_MAX_DEPTH" default:"0" description:"Maximum subdirectory depth to descend into (0 = top level only, -1 = unlimited)"`
+	ListingFormat     string        `flag:"listing-format" env:"CONFSYNC_LISTING_FORMAT" default:"auto" description:"Directory listing format (auto, json, html, xml, caddy, custom)"`
+	S3Region          string        `flag:"s3-region" env:"CONFSYNC_S3_REGION" default:"us-east-1" description:"Region used to sign S3 requests"`
+	S3AccessKeyFile   string        `flag:"s3-access-key-file" env:"CONFSYNC_S3_ACCESS_KEY_FILE" default:"" description:"File containing the S3 access key (default: AWS_ACCESS_KEY_ID)"`
+	S3SecretKeyFile   string        `flag:"s3-secret-key-file" env:"CONFSYNC_S3_SECRET_KEY_FILE" default:"" description:"File containing the S3 secret key (default: AWS_SECRET_ACCESS_KEY)"`
+	Manifest          string        `flag:"manifest" env:"CONFSYNC_MANIFEST" default:"" description:"Path of a JSON manifest with per-file SHA-256 checksums, relative to the remote URL (enables manifest mode)"`
+	Conditional       bool          `flag:"conditional" env:"CONFSYNC_CONDITIONAL" default:"true" description:"Send If-None-Match/If-Modified-Since and treat 304 Not Modified as unchanged"`
+	SignatureKey      string        `flag:"signature-public-key" env:"CONFSYNC_SIGNATURE_PUBLIC_KEY" default:"" description:"Public key file used to verify detached signatures of the listing or manifest (enables verification)"`
+	SignatureFormat   string        `flag:"signature-format" env:"CONFSYNC_SIGNATURE_FORMAT" default:"minisign" description:"Detached signature format (minisign, ed25519)"`
+	ListingMapping    string        `flag:"listing-mapping" env:"CONFSYNC_LISTING_MAPPING" default:"" description:"Field selectors for the custom listing format (e.g. root=$.items,name=path,type=kind,mtime=updated,size=bytes)"`
+	DriftCheck        string        `flag:"drift-check" env:"CONFSYNC_DRIFT_CHECK" default:"off" description:"Detect local changes to synced files by comparing size, mtime or hash with what was last written (off, size, mtime, hash)"`
+	DriftAction       string        `flag:"drift-action" env:"CONFSYNC_DRIFT_ACTION" default:"repair" description:"What to do with drifted files (repair, report)"`
+	Transactional     bool          `flag:"transactional" env:"CONFSYNC_TRANSACTIONAL" default:"false" description:"Stage all changes and apply them only if every download succeeds"`
+	Snapshot          bool          `flag:"snapshot" env:"CONFSYNC_SNAPSHOT" default:"false" description:"Write each sync as a new generation directory and swap a ..data symlink atomically"`
+	History           int           `flag:"history" env:"CONFSYNC_HISTORY" default:"0" description:"Number of committed generations to keep for rollback (0 disables)"`
+	HistoryDir        string        `flag:"history-dir" env:"CONFSYNC_HISTORY_DIR" default:".confsync-history" description:"Directory history generations are kept in, relative to the local directory"`
+	AdminTokenFile    string        `flag:"admin-token-file" env:"CONFSYNC_ADMIN_TOKEN_FILE" default:"" description:"File containing the bearer token for the /admin and /sync endpoints (enables them)"`
+	Hook              string        `flag:"hook" env:"CONFSYNC_HOOK" default:"" description:"Shell command run after each sync that changed files"`
+	HookTimeout       time.Duration `flag:"hook-timeout" env:"CONFSYNC_HOOK_TIMEOUT" default:"30s" description:"Maximum run time of the hook command (0 = unlimited)"`
+	HookDegrade       bool          `flag:"hook-degrade" env:"CONFSYNC_HOOK_DEGRADE" default:"true" description:"Report the sync as degraded when the hook command fails"`
+	SignalName        string        `flag:"signal" env:"CONFSYNC_SIGNAL" default:"HUP" description:"Signal sent to the target process after a change (e.g. HUP, USR1)"`
+	SignalPidFile     string        `flag:"signal-pidfile" env:"CONFSYNC_SIGNAL_PIDFILE" default:"" description:"Pidfile of the process to signal after a change"`
+	SignalProcess     string        `flag:"signal-process" env:"CONFSYNC_SIGNAL_PROCESS" default:"" description:"Name (as in /proc/<pid>/comm) of the processes to signal after a change"`
+	SignalPattern     string        `flag:"signal-pattern" env:"CONFSYNC_SIGNAL_PATTERN" default:"" description:"Regex of changed files that trigger the signal (default: any)"`
+	SignalDebounce    time.Duration `flag:"signal-debounce" env:"CONFSYNC_SIGNAL_DEBOUNCE" default:"2s" description:"Wait for changes to settle for this long before signalling"`
+	WebhookURL        string        `flag:"webhook-url" env:"CONFSYNC_WEBHOOK_URL" default:"" description:"Comma-separated URLs events are posted to as JSON"`
+	WebhookEvents     string        `flag:"webhook-events" env:"CONFSYNC_WEBHOOK_EVENTS" default:"" description:"Comma-separated events sent to the webhooks (files_changed, sync_failed, health_changed, signature_failed; default: all)"`
+	WebhookSecretFile string        `flag:"webhook-secret-file" env:"CONFSYNC_WEBHOOK_SECRET_FILE" default:"" description:"File containing the secret the webhook body is signed with (HMAC-SHA256)"`
+	WebhookTimeout    time.Duration `flag:"webhook-timeout" env:"CONFSYNC_WEBHOOK_TIMEOUT" default:"10s" description:"Timeout of a single webhook request"`
+	WebhookRetries    int           `flag:"webhook-retries" env:"CONFSYNC_WEBHOOK_RETRIES" default:"3" description:"Maximum number of retries for failed webhook requests"`
+	Watch             string        `flag:"watch" env:"CONFSYNC_WATCH" default:"" description:"Server-sent events or long-poll endpoint announcing new revisions, absolute or relative to the remote URL (enables watch mode)"`
+	WatchMode         string        `flag:"watch-mode" env:"CONFSYNC_WATCH_MODE" default:"sse" description:"Watch endpoint protocol (sse, longpoll)"`
+	WatchIdleTimeout  time.Duration `flag:"watch-idle-timeout" env:"CONFSYNC_WATCH_IDLE_TIMEOUT" default:"5m" description:"Reconnect when the watch endpoint sent nothing for this long (0 = never)"`
+	WatchMaxBackoff   time.Duration `flag:"watch-max-backoff" env:"CONFSYNC_WATCH_MAX_BACKOFF" default:"5m" description:"Maximum delay between watch reconnection attempts"`
+	Concurrency       int           `flag:"concurrency" env:"CONFSYNC_CONCURRENCY" default:"1" description:"Number of files downloaded in parallel"`
+	MaxBandwidth      string        `flag:"max-bandwidth" env:"CONFSYNC_MAX_BANDWIDTH" default:"" description:"Maximum total download rate in bytes per second, with an optional K, M or G suffix (default: unlimited)"`
+	Schedule          string        `flag:"schedule" env:"CONFSYNC_SCHEDULE" default:"" description:"Cron expression to sync on instead of the poll interval (e.g. */5 * * * *, @hourly)"`
+	Jitter            time.Duration `flag:"jitter" env:"CONFSYNC_JITTER" default:"0s" description:"Random delay of up to this much added to every scheduled sync"`
+	Splay             time.Duration `flag:"splay" env:"CONFSYNC_SPLAY" default:"0s" description:"Random delay of up to this much before the initial sync"`
+	Freeze            string        `flag:"freeze" env:"CONFSYNC_FREEZE" default:"" description:"Semicolon-separated windows in which changes are detected but not applied (e.g. Mon-Fri 18:00-08:00; Sat,Sun)"`
+	BreakerThreshold  int           `flag:"breaker-threshold" env:"CONFSYNC_BREAKER_THRESHOLD" default:"0" description:"Consecutive failed syncs that open the circuit breaker (0 disables it)"`
+	BreakerProbe      time.Duration `flag:"breaker-probe" env:"CONFSYNC_BREAKER_PROBE" default:"5m" description:"Interval between probe syncs while the circuit breaker is open"`
+	Mirrors           string        `flag:"mirrors" env:"CONFSYNC_MIRRORS" default:"" description:"Comma-separated fallback URLs serving the same content as the remote URL, tried in order"`
+	MirrorRecheck     time.Duration `flag:"mirror-recheck" env:"CONFSYNC_MIRROR_RECHECK" default:"5m" description:"How long a failed mirror is skipped before it is preferred again"`
+	Overlay           string        `flag:"overlay" env:"CONFSYNC_OVERLAY" default:"" description:"Comma-separated URLs of sources merged over the remote URL, later ones winning for files with the same name"`
+	Jobs              string        `flag:"jobs" env:"CONFSYNC_JOBS" default:"" description:"JSON file with a list of sync jobs run by this process, each with its own url, dir and other options"`
+	StateFile         string        `flag:"state-file" env:"CONFSYNC_STATE_FILE" default:"" description:"File the sync state is persisted to across restarts, relative to the local directory (empty disables)"`
 }
 
 // HealthStatus represents the health status of the application
 type HealthStatus struct {
-	Status        string            `json:"status"`
-	Timestamp     time.Time         `json:"timestamp"`
-	LastSync      time.Time         `json:"last_sync,omitempty"`
-	LastError     string            `json:"last_error,omitempty"`
-	SyncedFiles   int64             `json:"synced_files"`
-	TotalRequests int64             `json:"total_requests"`
-	FailedSyncs   int64             `json:"failed_syncs"`
-	Uptime        time.Duration     `json:"uptime"`
-	Config        map[string]string `json:"config"`
+	Status          string            `json:"status"`
+	Timestamp       time.Time         `json:"timestamp"`
+	LastSync        time.Time         `json:"last_sync,omitempty"`
+	LastError       string            `json:"last_error,omitempty"`
+	SyncedFiles     int64             `json:"synced_files"`
+	TotalRequests   int64             `json:"total_requests"`
+	FailedSyncs     int64             `json:"failed_syncs"`
+	FailedDownloads int64             `json:"failed_downloads"`
+	HookFailures    int64             `json:"hook_failures"`
+	DriftedFiles    int64             `json:"drifted_files"`
+	Pinned          int               `json:"pinned_generation,omitempty"`
+	LastHook        *HookResult       `json:"last_hook,omitempty"`
+	SignalsSent     int64             `json:"signals_sent"`
+	Watching        bool              `json:"watching"`
+	Frozen          bool              `json:"frozen"`
+	Deferred        *syncChanges      `json:"deferred_changes,omitempty"`
+	Breaker         string            `json:"breaker,omitempty"`
+	Mirror          string            `json:"mirror,omitempty"`
+	FileSources     map[string]string `json:"file_sources,omitempty"`
+	Uptime          time.Duration     `json:"uptime"`
+	Config          map[string]string `json:"config"`
 }
 
 // ConfsyncApp represents the main application
 type ConfsyncApp struct {
-	config         Config
-	listingClient  *http.Client
-	downloadClient *http.Client
-	fileRegex      *regexp.Regexp
-	fileCache      map[string]FileEntry
-	startTime      time.Time
-	lastSync       time.Time
-	lastError      string
-	syncedFiles    int64
-	totalReqs      int64
-	failedSyncs    int64
-	mu             sync.RWMutex
-	healthServer   *http.Server
-	downloadCancel context.CancelFunc
-	downloadCtx    context.Context
-}
-
-// NewConfsyncApp creates a new instance of the application
+	config           Config
+	jobName          string
+	logger           *log.Logger
+	listingClient    *http.Client
+	downloadClient   *http.Client
+	fileRegex        *regexp.Regexp
+	listingParser    ListingParser
+	s3               *s3Client
+	verifier         *signatureVerifier
+	limiter          *rateLimiter
+	partialMu        sync.Mutex
+	partials         map[string]partialDownload
+	conditionalMu    sync.Mutex
+	conditionalCache map[string]conditionalEntry
+	fileCache        map[string]FileEntry
+	localMu          sync.Mutex
+	localFiles       map[string]localFileInfo
+	startTime        time.Time
+	lastSync         time.Time
+	lastError        string
+	syncedFiles      int64
+	totalReqs        int64
+	failedSyncs      int64
+	failedDownloads  int64
+	hookFailures     int64
+	driftedFiles     int64
+	pinned           int
+	lastHook         *HookResult
+	signaler         *processSignaler
+	webhooks         *webhookNotifier
+	watcher          *watcher
+	schedule         *cronSchedule
+	freezeWindows    []freezeWindow
+	breaker          *circuitBreaker
+	mirrors          *mirrorSet
+	overlays         []string
+	fileSources      map[string]string
+	listedFrom       string
+	deferred         *syncChanges
+	reportedStatus   string
+	adminToken       string
+	syncMu           sync.Mutex
+	triggerMu        sync.Mutex
+	triggerRunMu     sync.Mutex
+	queuedRun        *syncRun
+	triggeredSyncs   sync.WaitGroup
+	mu               sync.RWMutex
+	healthServer     *http.Server
+	shutdownCtx      context.Context
+	shutdownCancel   context.CancelFunc
+	downloadCancel   context.CancelFunc
+	downloadCtx      context.Context
+}
+
+// NewConfsyncApp creates a new instance of the application, logging to the standard logger
 func NewConfsyncApp(config Config) (*ConfsyncApp, error) {
+	return newConfsyncAppWithLogger(config, log.Default())
+}
+
+// newConfsyncAppWithLogger creates a new instance of the application that logs to logger
+func newConfsyncAppWithLogger(config Config, logger *log.Logger) (*ConfsyncApp, error) {
 	regex, err := regexp.Compile(config.FilePattern)
 	if err != nil {
 		return nil, fmt.Errorf("invalid file pattern regex: %w", err)
 	}
 
+	if config.ListingFormat == "" {
+		config.ListingFormat = listingFormatAuto
+	}
+	listingParser, err := newListingParser(config.ListingFormat, config.ListingMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateDriftConfig(&config); err != nil {
+		return nil, err
+	}
+
+	signaler, err := newProcessSignaler(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := newWebhookNotifier(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	bandwidth, err := parseBandwidth(config.MaxBandwidth)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedule *cronSchedule
+	if config.Schedule != "" {
+		if schedule, err = parseCron(config.Schedule); err != nil {
+			return nil, err
+		}
+	}
+	freezeWindows, err := parseFreezeWindows(config.Freeze)
+	if err != nil {
+		return nil, err
+	}
+
+	var adminToken string
+	if config.AdminTokenFile != "" {
+		data, err := os.ReadFile(config.AdminTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin token: %w", err)
+		}
+		if adminToken = strings.TrimSpace(string(data)); adminToken == "" {
+			return nil, fmt.Errorf("admin token file %s is empty", config.AdminTokenFile)
+		}
+	}
+
+	var s3 *s3Client
+	switch config.SourceType {
+	case "", sourceHTTP:
+		config.SourceType = sourceHTTP
+	case sourceS3:
+		if s3, err = newS3Client(config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid source type %q: must be one of http, s3", config.SourceType)
+	}
+
+	var verifier *signatureVerifier
+	if config.SignatureKey != "" {
+		if config.SignatureFormat == "" {
+			config.SignatureFormat = signatureFormatMinisign
+		}
+		if verifier, err = newSignatureVerifier(config.SignatureFormat, config.SignatureKey); err != nil {
+			return nil, err
+		}
+		// Bucket listings are generated by the server and can't be signed, so only a manifest can be verified
+		if s3 != nil && config.Manifest == "" {
+			return nil, fmt.Errorf("signature verification with the s3 source requires manifest mode")
+		}
+	}
+
 	// Create separate HTTP clients for listing and downloads
 	listingClient := &http.Client{
 		Timeout: config.ConnectTimeout,
@@ -93,85 +283,351 @@ func NewConfsyncApp(config Config) (*ConfsyncApp, error) {
 		// No timeout for downloads - we'll use context for cancellation
 	}
 
-	// Create download context that can be cancelled
-	downloadCtx, downloadCancel := context.WithCancel(context.Background())
+	mirrors, err := newMirrorSet(config.RemoteURL, config.Mirrors, config.MirrorRecheck, logger)
+	if err != nil {
+		return nil, err
+	}
+	if mirrors != nil && s3 != nil {
+		return nil, fmt.Errorf("mirrors are not supported with the s3 source")
+	}
+	overlays, err := parseOverlays(config.Overlay)
+	if err != nil {
+		return nil, err
+	}
+	if overlays != nil && s3 != nil {
+		return nil, fmt.Errorf("overlays are not supported with the s3 source")
+	}
+
+	watcher, err := newWatcher(config, downloadClient, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every sync derives its download context from the shutdown context, which drain cancels
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	downloadCtx, downloadCancel := context.WithCancel(shutdownCtx)
+
+	app := &ConfsyncApp{
+		config:           config,
+		listingClient:    listingClient,
+		downloadClient:   downloadClient,
+		fileRegex:        regex,
+		listingParser:    listingParser,
+		s3:               s3,
+		verifier:         verifier,
+		limiter:          newRateLimiter(bandwidth),
+		adminToken:       adminToken,
+		signaler:         signaler,
+		webhooks:         webhooks,
+		watcher:          watcher,
+		schedule:         schedule,
+		freezeWindows:    freezeWindows,
+		breaker:          newCircuitBreaker(config.BreakerThreshold, config.BreakerProbe, logger),
+		mirrors:          mirrors,
+		overlays:         overlays,
+		reportedStatus:   "healthy",
+		fileCache:        make(map[string]FileEntry),
+		conditionalCache: make(map[string]conditionalEntry),
+		partials:         make(map[string]partialDownload),
+		localFiles:       make(map[string]localFileInfo),
+		startTime:        time.Now(),
+		logger:           logger,
+		shutdownCtx:      shutdownCtx,
+		shutdownCancel:   shutdownCancel,
+		downloadCtx:      downloadCtx,
+		downloadCancel:   downloadCancel,
+	}
+
+	// A missing or unreadable state only costs a full sync, so it doesn't prevent startup
+	if err := app.loadState(); err != nil {
+		app.logger.Printf("Warning: ignoring sync state: %v", err)
+	}
+
+	return app, nil
+}
+
+// remoteURL builds the URL of a path relative to the remote base URL of the current sync
+func (app *ConfsyncApp) remoteURL(relPath string) string {
+	return mirrorURL(app.baseURL(), relPath)
+}
+
+// mirrorURL builds the URL of a path relative to a base URL, escaping each path segment
+func mirrorURL(base, relPath string) string {
+	segments := strings.Split(relPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.Join(segments, "/")
+}
+
+// maxListingDepth caps how deep an unlimited max depth descends, so that a directory cycle on the
+// server, such as a symlink to a parent directory, can't make the walk go on forever
+const maxListingDepth = 32
+
+// isSafeEntryName reports whether a listing entry name is a single, non-traversing path segment
+func isSafeEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// fetchRemoteTree fetches the listing at a base URL and, up to the configured max depth,
+// the listings of its subdirectories. Returned file entries are named by their
+// slash-separated path relative to the base URL.
+func (app *ConfsyncApp) fetchRemoteTree(base string) ([]FileEntry, error) {
+	if app.config.Manifest != "" {
+		return app.fetchManifest(base)
+	}
+
+	if app.s3 != nil {
+		return app.listS3Objects()
+	}
+
+	var files []FileEntry
+
+	var walk func(relDir string, depth int) error
+	walk = func(relDir string, depth int) error {
+		listingURL := base
+		if relDir != "" {
+			listingURL = mirrorURL(base, relDir) + "/"
+		}
+
+		entries, err := app.fetchDirectoryListing(listingURL)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !isSafeEntryName(entry.Name) {
+				app.logger.Printf("Warning: ignoring listing entry with unsafe name %q", entry.Name)
+				continue
+			}
+
+			relPath := entry.Name
+			if relDir != "" {
+				relPath = relDir + "/" + entry.Name
+			}
+
+			switch entry.Type {
+			case "file":
+				entry.Name = relPath
+				// HTML listings don't always expose metadata; look it up for files we would sync
+				if (entry.MTime == "" || entry.Size < 0) && app.fileRegex.MatchString(relPath) {
+					mtime, size, err := app.fetchFileInfo(mirrorURL(base, relPath))
+					if err != nil {
+						return fmt.Errorf("failed to fetch file info for %s: %w", relPath, err)
+					}
+					if entry.MTime == "" {
+						entry.MTime = mtime
+					}
+					if entry.Size < 0 {
+						entry.Size = size
+					}
+				}
+				files = append(files, entry)
+			case "directory":
+				if app.config.MaxDepth >= 0 && depth >= app.config.MaxDepth {
+					continue
+				}
+				if app.config.MaxDepth < 0 && depth >= maxListingDepth {
+					app.logger.Printf("Warning: not descending into %s, which is more than %d levels deep (a directory cycle on the server?)", relPath, maxListingDepth)
+					continue
+				}
+				if err := walk(relPath, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk("", 0); err != nil {
+		return nil, err
+	}
 
-	return &ConfsyncApp{
-		config:         config,
-		listingClient:  listingClient,
-		downloadClient: downloadClient,
-		fileRegex:      regex,
-		fileCache:      make(map[string]FileEntry),
-		startTime:      time.Now(),
-		downloadCtx:    downloadCtx,
-		downloadCancel: downloadCancel,
-	}, nil
+	return files, nil
 }
 
-// fetchDirectoryListing fetches the directory listing from the remote server
-func (app *ConfsyncApp) fetchDirectoryListing() ([]FileEntry, error) {
+// fetchDirectoryListing fetches a single directory listing from the remote server
+func (app *ConfsyncApp) fetchDirectoryListing(listingURL string) ([]FileEntry, error) {
 	atomic.AddInt64(&app.totalReqs, 1)
 
 	var entries []FileEntry
+
+	err := app.fetchWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", listingURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", app.config.UserAgent)
+		if app.listingParser != nil {
+			req.Header.Set("Accept", app.listingParser.Accept())
+		} else {
+			req.Header.Set("Accept", autoListingAccept)
+		}
+		app.addConditionalHeaders(req)
+		return req, nil
+	}, func(resp *http.Response, body []byte) error {
+		// The listing was verified and parsed when it was first fetched
+		if resp.StatusCode == http.StatusNotModified {
+			cached, ok := app.cachedListing(listingURL)
+			if !ok {
+				app.forgetValidators(listingURL)
+				return fmt.Errorf("server returned 304 for a listing that is not cached")
+			}
+			entries = cached
+			return nil
+		}
+
+		if app.verifier != nil {
+			signatureURL, err := app.verifier.listingSignatureURL(listingURL)
+			if err != nil {
+				return err
+			}
+			if err := app.verifyDetachedSignature(body, func() (*http.Request, error) {
+				req, err := http.NewRequest("GET", signatureURL, nil)
+				if err != nil {
+					return nil, err
+				}
+				req.Header.Set("User-Agent", app.config.UserAgent)
+				return req, nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		parser := app.listingParser
+		if parser == nil {
+			parser = detectListingParser(resp.Header.Get("Content-Type"), body)
+		}
+
+		var err error
+		if entries, err = parser.Parse(body); err != nil {
+			return err
+		}
+		// Unless the format was set explicitly, an HTML page without entries is more likely an
+		// error page than an empty directory, and must not remove every local file
+		if _, isHTML := parser.(htmlListingParser); isHTML && app.listingParser == nil && len(entries) == 0 {
+			return fmt.Errorf("HTML listing without entries, set -listing-format=html if the directory is empty")
+		}
+
+		app.rememberValidators(listingURL, resp.Header, append([]FileEntry{}, entries...))
+		return nil
+	})
+	if err != nil {
+		// Another mirror may still serve the listing
+		if !app.canFailOver() {
+			app.setLastError(fmt.Sprintf("failed after %d retries: %v", app.config.MaxRetries, err))
+		}
+		return nil, fmt.Errorf("failed after %d retries: %w", app.config.MaxRetries, err)
+	}
+
+	return entries, nil
+}
+
+// fetchWithRetries sends the request built by newRequest and passes the response body to handle,
+// retrying with exponential backoff until handle succeeds or MaxRetries is exhausted.
+// Both 200 and 304 responses are passed to handle.
+func (app *ConfsyncApp) fetchWithRetries(newRequest func() (*http.Request, error), handle func(resp *http.Response, body []byte) error) error {
 	var lastErr error
 
-	for retry := 0; retry <= app.config.MaxRetries; retry++ {
+	// Probes of a remote that keeps failing get a single attempt, so that they don't block the sync loop,
+	// and so do requests that can fail over to another mirror
+	maxRetries := app.config.MaxRetries
+	if app.breaker.probing() || app.canFailOver() {
+		maxRetries = 0
+	}
+
+	for retry := 0; retry <= maxRetries; retry++ {
 		if retry > 0 {
-			// Exponential backoff: base delay * 2^(retry-1)
-			backoffDelay := time.Duration(int64(app.config.RetryDelay) * int64(1<<(retry-1)))
+			// Exponential backoff: base delay * 2^(retry-1), unless the server asked for a longer delay
+			backoffDelay := app.backoffDelay(retry, lastErr)
 			if app.config.Verbose {
-				log.Printf("Retrying request (attempt %d/%d) after %v", retry, app.config.MaxRetries, backoffDelay)
+				app.logger.Printf("Retrying request (attempt %d/%d) after %v", retry, maxRetries, backoffDelay)
 			}
 			time.Sleep(backoffDelay)
 		}
 
-		req, err := http.NewRequest("GET", app.config.RemoteURL, nil)
+		req, err := newRequest()
 		if err != nil {
 			lastErr = fmt.Errorf("failed to create request: %w", err)
 			continue
 		}
-
-		req.Header.Set("User-Agent", app.config.UserAgent)
-		req.Header.Set("Accept", "application/json")
+		if err := app.signRequest(req); err != nil {
+			lastErr = err
+			continue
+		}
 
 		resp, err := app.listingClient.Do(req)
 		if err != nil {
-			lastErr = fmt.Errorf("failed to fetch directory listing: %w", err)
+			lastErr = fmt.Errorf("failed to fetch %s: %w", req.URL.Redacted(), err)
 			continue
 		}
 
-		defer func() {
-			if closeErr := resp.Body.Close(); closeErr != nil {
-				log.Printf("Failed to close response body: %v", closeErr)
-			}
-		}()
+		body, err := io.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			app.logger.Printf("Failed to close response body: %v", closeErr)
+		}
 
-		if resp.StatusCode != http.StatusOK {
-			lastErr = fmt.Errorf("server returned status %d: %s", resp.StatusCode, resp.Status)
+		// 304 is only possible for conditional requests, whose handlers know how to deal with it
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+			statusErr := newHTTPStatusError(resp)
+			lastErr = statusErr
+			// Client errors won't go away by asking again
+			if !statusErr.retryable() {
+				return lastErr
+			}
 			continue
 		}
 
-		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			lastErr = fmt.Errorf("failed to read response body: %w", err)
 			continue
 		}
 
-		if err := json.Unmarshal(body, &entries); err != nil {
-			lastErr = fmt.Errorf("failed to parse JSON response: %w", err)
+		if err := handle(resp, body); err != nil {
+			lastErr = err
 			continue
 		}
 
-		return entries, nil
+		return nil
 	}
 
-	app.setLastError(fmt.Sprintf("failed after %d retries: %v", app.config.MaxRetries, lastErr))
-	return nil, fmt.Errorf("failed after %d retries: %w", app.config.MaxRetries, lastErr)
+	return lastErr
+}
+
+// fileURL returns the URL a file relative to the remote URL is downloaded from
+func (app *ConfsyncApp) fileURL(relPath string) string {
+	if app.s3 != nil {
+		return app.s3.requestURL(app.s3.prefix+relPath, nil).String()
+	}
+	return app.remoteURL(relPath)
 }
 
-// downloadFile downloads a file from the remote server with context-based cancellation
-func (app *ConfsyncApp) downloadFile(filename string) error {
-	fileURL := strings.TrimSuffix(app.config.RemoteURL, "/") + "/" + filename
+// newFileRequest builds a GET request for a file relative to the remote base URL
+func (app *ConfsyncApp) newFileRequest(ctx context.Context, base, relPath string) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if app.s3 != nil {
+		req, err = app.s3.objectRequest(ctx, relPath)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "GET", mirrorURL(base, relPath), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", app.config.UserAgent)
+	return req, nil
+}
+
+// downloadFile downloads a file from the remote base URL into root (the local directory, or a
+// new generation in snapshot mode) with context-based cancellation. When the entry carries a
+// SHA-256 digest, the downloaded content must match it before the file is moved into place.
+// Interrupted downloads keep their temporary file and resume from where they stopped when the
+// server supports range requests.
+func (app *ConfsyncApp) downloadFile(entry FileEntry, root, base string) error {
+	filename := entry.Name
 
 	// Create download context with timeout if specified
 	ctx := app.downloadCtx
@@ -181,12 +637,31 @@ func (app *ConfsyncApp) downloadFile(filename string) error {
 		defer cancel()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	req, err := app.newFileRequest(ctx, base, filename)
 	if err != nil {
 		return fmt.Errorf("failed to create request for %s: %w", filename, err)
 	}
 
-	req.Header.Set("User-Agent", app.config.UserAgent)
+	localPath := filepath.Join(root, filepath.FromSlash(filename))
+	fileURL := req.URL.String()
+
+	// Only ask for a 304 when there is a local copy to keep. Entries with a digest are
+	// only downloaded when the local copy doesn't match, so they are always fetched in full.
+	if _, err := os.Stat(filepath.Join(app.config.LocalDir, filepath.FromSlash(filename))); err == nil && entry.SHA256 == "" {
+		app.addConditionalHeaders(req)
+	}
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+
+	// Ask for the rest of an interrupted download, unless the remote file changed since
+	tempPath := app.partialPath(filename)
+	partial, resuming := app.partialFor(tempPath)
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", partial.size))
+		req.Header.Set("If-Range", partial.validator)
+	}
+	if err := app.signRequest(req); err != nil {
+		return fmt.Errorf("failed to create request for %s: %w", filename, err)
+	}
 
 	resp, err := app.downloadClient.Do(req)
 	if err != nil {
@@ -200,37 +675,84 @@ func (app *ConfsyncApp) downloadFile(filename string) error {
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("Failed to close response body: %v", closeErr)
+			app.logger.Printf("Failed to close response body: %v", closeErr)
 		}
 	}()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download %s: server returned status %d", filename, resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		if app.config.Verbose {
+			app.logger.Printf("Not modified: %s", filename)
+		}
+		return errNotModified
+	}
+
+	// The partial file is longer than the remote file, so it can't be resumed
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && resuming {
+		app.discardPartial(tempPath)
+		return app.downloadFile(entry, root, base)
 	}
 
-	localPath := filepath.Join(app.config.LocalDir, filename)
-	localDir := filepath.Dir(localPath)
+	resumed := resp.StatusCode == http.StatusPartialContent && resuming
+	if resumed {
+		if start, err := parseContentRangeStart(resp.Header.Get("Content-Range")); err != nil || start != partial.size {
+			app.discardPartial(tempPath)
+			return fmt.Errorf("failed to resume download of %s at %d bytes: unexpected Content-Range %q",
+				filename, partial.size, resp.Header.Get("Content-Range"))
+		}
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %w", filename, newHTTPStatusError(resp))
+	}
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(localDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", localDir, err)
+	// Create directories if they don't exist
+	for _, dir := range []string{filepath.Dir(localPath), filepath.Dir(tempPath)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
 	}
 
-	// Create temporary file first
-	tempPath := localPath + ".tmp"
-	tempFile, err := os.Create(tempPath)
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file %s: %w", tempPath, err)
+	// Create temporary file first, or append to the partial file when resuming. The content
+	// already received is hashed first so that the digest covers the whole file.
+	hasher := sha256.New()
+	var tempFile *os.File
+	if resumed {
+		if tempFile, err = os.OpenFile(tempPath, os.O_RDWR, 0); err == nil {
+			if _, err = io.Copy(hasher, tempFile); err != nil {
+				tempFile.Close()
+			}
+		}
+		if err != nil {
+			app.discardPartial(tempPath)
+			return fmt.Errorf("failed to open partial file %s: %w", tempPath, err)
+		}
+		if app.config.Verbose {
+			app.logger.Printf("Resuming download of %s at %d bytes", filename, partial.size)
+		}
+	} else {
+		app.forgetPartial(tempPath)
+		if tempFile, err = os.Create(tempPath); err != nil {
+			return fmt.Errorf("failed to create temporary file %s: %w", tempPath, err)
+		}
 	}
 
-	// Copy content to temporary file with context cancellation support
-	_, err = io.Copy(tempFile, resp.Body)
+	// Copy content to temporary file with context cancellation support, hashing it on the way
+	var body io.Reader = resp.Body
+	if app.limiter != nil {
+		body = &throttledReader{r: resp.Body, ctx: ctx, limiter: app.limiter}
+	}
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), body)
 	if closeErr := tempFile.Close(); closeErr != nil {
-		log.Printf("Failed to close temporary file: %v", closeErr)
+		app.logger.Printf("Failed to close temporary file: %v", closeErr)
 	}
 	if err != nil {
-		if removeErr := os.Remove(tempPath); removeErr != nil {
-			log.Printf("Failed to remove temporary file %s: %v", tempPath, removeErr)
+		// Keep what was received if the download can be resumed later
+		validator := resumeValidator(resp.Header)
+		if resumed && validator == "" {
+			validator = partial.validator
+		}
+		if validator != "" && (resumed || resp.Header.Get("Accept-Ranges") == "bytes") {
+			app.keepPartial(tempPath, validator)
+		} else {
+			app.discardPartial(tempPath)
 		}
 		if ctx.Err() == context.Canceled {
 			return fmt.Errorf("download of %s was cancelled during file write", filename)
@@ -238,16 +760,42 @@ func (app *ConfsyncApp) downloadFile(filename string) error {
 		return fmt.Errorf("failed to write to temporary file %s: %w", tempPath, err)
 	}
 
+	// Refuse to install a file that doesn't have the size from the listing, e.g. a truncated or badly resumed download
+	if entry.Size > 0 {
+		if info, err := os.Stat(tempPath); err != nil || info.Size() != entry.Size {
+			var size int64
+			if err == nil {
+				size = info.Size()
+			}
+			app.discardPartial(tempPath)
+			return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", filename, entry.Size, size)
+		}
+	}
+
+	// Refuse to install content that doesn't match the expected digest
+	if entry.SHA256 != "" {
+		if digest := hex.EncodeToString(hasher.Sum(nil)); digest != entry.SHA256 {
+			app.discardPartial(tempPath)
+			return fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", filename, entry.SHA256, digest)
+		}
+	}
+
 	// Atomically move temporary file to final location
 	if err := os.Rename(tempPath, localPath); err != nil {
 		if removeErr := os.Remove(tempPath); removeErr != nil {
-			log.Printf("Failed to remove temporary file %s: %v", tempPath, removeErr)
+			app.logger.Printf("Failed to remove temporary file %s: %v", tempPath, removeErr)
 		}
 		return fmt.Errorf("failed to move temporary file to %s: %w", localPath, err)
 	}
 
+	app.forgetPartial(tempPath)
+	app.rememberValidators(fileURL, resp.Header, nil)
+	if info, err := os.Stat(localPath); err == nil {
+		app.recordLocalFile(filename, localFileInfo{Size: info.Size(), ModTime: info.ModTime(), SHA256: hex.EncodeToString(hasher.Sum(nil))})
+	}
+
 	if app.config.Verbose {
-		log.Printf("Downloaded: %s", filename)
+		app.logger.Printf("Downloaded: %s", filename)
 	}
 
 	return nil
@@ -255,13 +803,22 @@ func (app *ConfsyncApp) downloadFile(filename string) error {
 
 // syncFiles synchronizes files based on the directory listing
 func (app *ConfsyncApp) syncFiles() error {
+	app.syncMu.Lock()
+	defer app.syncMu.Unlock()
+
+	// A rollback pins the local directory to a history generation until released
+	if app.checkPin() {
+		return nil
+	}
+
 	// Cancel any ongoing downloads from previous sync
 	app.downloadCancel()
 
 	// Create new download context for this sync iteration
-	app.downloadCtx, app.downloadCancel = context.WithCancel(context.Background())
+	app.downloadCtx, app.downloadCancel = context.WithCancel(app.shutdownCtx)
+	defer app.cleanPartials()
 
-	entries, err := app.fetchDirectoryListing()
+	entries, err := app.fetchSources()
 	if err != nil {
 		return err
 	}
@@ -276,32 +833,65 @@ func (app *ConfsyncApp) syncFiles() error {
 			continue
 		}
 
-		if !app.fileRegex.MatchString(entry.Name) {
+		if !app.fileRegex.MatchString(entry.Name) || app.isInternalFile(entry.Name) {
 			continue
 		}
 
 		newCache[entry.Name] = entry
 
 		// Check if file needs to be synced (new or modified)
-		if cachedEntry, exists := app.fileCache[entry.Name]; !exists || cachedEntry.MTime != entry.MTime || cachedEntry.Size != entry.Size {
+		if cachedEntry, exists := app.fileCache[entry.Name]; !exists || entryChanged(cachedEntry, entry) {
+			// With a known digest, a local copy that already matches needs no download
+			if entry.SHA256 != "" && app.localFileMatches(entry) {
+				continue
+			}
 			filesToSync = append(filesToSync, entry)
 		}
 	}
 
+	// Files modified or removed locally are fetched again, or only reported
+	drifted := app.checkDrift(newCache, filesToSync)
+	atomic.StoreInt64(&app.driftedFiles, int64(len(drifted)))
+	for _, entry := range drifted {
+		if app.config.DriftAction == driftActionReport {
+			app.logger.Printf("Warning: local file %s was modified or removed since it was synced", entry.Name)
+			continue
+		}
+		app.logger.Printf("Local file %s was modified or removed since it was synced, fetching it again", entry.Name)
+		// A 304 would leave the local changes in place
+		app.forgetFileValidators(entry.Name)
+		filesToSync = append(filesToSync, entry)
+	}
+
+	// In snapshot mode the previous generation stands in for the local directory. The first
+	// generation takes over the matching files of a plain local directory instead.
+	var previous string
+	var previousFiles []string
+	initialGeneration := false
+	if app.config.Snapshot {
+		if previous = app.currentGeneration(); previous != "" {
+			previousFiles, err = listGeneration(previous)
+		} else {
+			initialGeneration = true
+			previous = app.config.LocalDir
+			previousFiles, err = app.scanMatchingFiles()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list local files: %w", err)
+		}
+	}
+
 	// Identify files to remove (only if deletion is enabled and listing was successful)
 	if app.config.DeleteFiles {
-		// Scan local directory for files to potentially remove
-		entries, err := os.ReadDir(app.config.LocalDir)
+		// Scan local directory tree for files to potentially remove
+		localFiles := previousFiles
+		if !app.config.Snapshot {
+			localFiles, err = app.scanLocalFiles()
+		}
 		if err != nil {
-			log.Printf("Warning: could not scan local directory for cleanup: %v", err)
+			app.logger.Printf("Warning: could not scan local directory for cleanup: %v", err)
 		} else {
-			for _, entry := range entries {
-				if entry.IsDir() {
-					continue
-				}
-
-				filename := entry.Name()
-
+			for _, filename := range localFiles {
 				// Only consider files that match our pattern
 				if !app.fileRegex.MatchString(filename) {
 					continue
@@ -315,38 +905,108 @@ func (app *ConfsyncApp) syncFiles() error {
 		}
 	}
 
-	// Remove files BEFORE downloading new ones (safer approach)
-	removedCount := 0
-	for _, filename := range filesToRemove {
-		localPath := filepath.Join(app.config.LocalDir, filename)
-		if err := os.Remove(localPath); err != nil {
-			log.Printf("Error removing %s: %v", localPath, err)
-		} else {
-			removedCount++
-			if app.config.Verbose {
-				log.Printf("Removed: %s", filename)
-			}
+	// During a freeze window changes are detected but left for the first sync after it
+	if (len(filesToSync) > 0 || len(filesToRemove) > 0) && app.frozen(time.Now()) {
+		app.deferChanges(filesToSync, filesToRemove)
+		return nil
+	}
+	app.clearDeferredChanges()
+
+	// Snapshot mode writes a new generation only when something changed
+	root := app.config.LocalDir
+	var generation string
+	if app.config.Snapshot && (initialGeneration || len(filesToSync) > 0 || len(filesToRemove) > 0) {
+		if generation, err = app.newGeneration(); err != nil {
+			return err
 		}
+		root = generation
+	}
+
+	// A transactional sync stages every change and applies them together at the end
+	var tx *transaction
+	if app.config.Transactional && (generation != "" || len(filesToSync) > 0 || len(filesToRemove) > 0) {
+		if tx, err = app.beginTransaction(filesToSync, filesToRemove, generation); err != nil {
+			return err
+		}
+		root = tx.root
+	}
+
+	// Remove files BEFORE downloading new ones (safer approach). A new generation
+	// simply doesn't receive them, and a transaction removes them on commit.
+	var removed []string
+	if app.config.Snapshot && !initialGeneration {
+		removed = filesToRemove
+		for _, filename := range removed {
+			app.forgetLocalFile(filename)
+		}
+	} else if tx == nil {
+		removed = app.removeFiles(filesToRemove)
 	}
 
 	// Download new/modified files
-	downloadedCount := 0
-	for _, entry := range filesToSync {
-		if err := app.downloadFile(entry.Name); err != nil {
+	results := app.downloadFiles(filesToSync, root, tx != nil)
+
+	// Nothing staged so far is applied when a single download fails
+	if tx != nil {
+		for _, err := range results {
+			if err != nil && !errors.Is(err, errNotModified) && !errors.Is(err, errDownloadSkipped) {
+				tx.abort()
+				return fmt.Errorf("transaction aborted, no changes applied: %w", err)
+			}
+		}
+	}
+
+	var staged []string
+	var failed downloadErrors
+	cancelled := false
+	for i, entry := range filesToSync {
+		err := results[i]
+		switch {
+		case err == nil:
+			staged = append(staged, entry.Name)
+		case errors.Is(err, errNotModified):
+		case strings.Contains(err.Error(), "cancelled"):
 			// Check if error is due to cancellation (next sync started)
-			if strings.Contains(err.Error(), "cancelled") {
-				log.Printf("Download of %s cancelled due to new sync iteration", entry.Name)
-				break // Stop processing downloads as new sync has started
+			if !cancelled {
+				app.logger.Printf("Download of %s cancelled due to new sync iteration", entry.Name)
+				cancelled = true
 			}
-			log.Printf("Error downloading %s: %v", entry.Name, err)
-			continue
+			app.restoreCacheEntry(newCache, entry.Name)
+		default:
+			app.logger.Printf("Error downloading %s: %v", entry.Name, err)
+			// Keep the previous cache state so the file is retried on the next sync
+			app.restoreCacheEntry(newCache, entry.Name)
+			failed = append(failed, err)
 		}
-		downloadedCount++
-		atomic.AddInt64(&app.syncedFiles, 1)
 	}
 
+	if generation != "" {
+		if err := app.commitGeneration(generation, previous, previousFiles, filesToRemove, initialGeneration || len(staged)+len(removed) > 0); err != nil {
+			// commitGeneration already discarded the generation if it wasn't activated
+			if tx != nil {
+				tx.restoreRecords()
+			}
+			return err
+		}
+		if tx != nil && initialGeneration {
+			removed = app.removeFiles(filesToRemove)
+		}
+	} else if tx != nil {
+		if err := tx.commit(staged, filesToRemove); err != nil {
+			return fmt.Errorf("transaction aborted, no changes applied: %w", err)
+		}
+		removed = filesToRemove
+		for _, filename := range removed {
+			app.forgetLocalFile(filename)
+		}
+	}
+	atomic.AddInt64(&app.syncedFiles, int64(len(staged)))
+	changes := app.describeChanges(staged, removed)
+
 	// Update cache only after successful operations
 	app.fileCache = newCache
+	app.listedFrom = app.baseURL()
+	app.setFileSources(newCache)
 
 	// Update sync status
 	app.mu.Lock()
@@ -356,17 +1016,140 @@ func (app *ConfsyncApp) syncFiles() error {
 	}
 	app.mu.Unlock()
 
+	if err := app.saveState(); err != nil {
+		app.logger.Printf("Warning: could not save sync state: %v", err)
+	}
+
+	if app.historyPath() != "" {
+		if err := app.maybeRecordHistory(newCache, !changes.empty()); err != nil {
+			app.logger.Printf("Warning: could not record history: %v", err)
+		}
+	}
+
 	// Log summary
-	if downloadedCount > 0 || removedCount > 0 {
-		log.Printf("Sync complete: downloaded %d, removed %d files matching pattern '%s'",
-			downloadedCount, removedCount, app.config.FilePattern)
+	if !changes.empty() {
+		app.logger.Printf("Sync complete: downloaded %d, removed %d files matching pattern '%s'",
+			len(staged), len(removed), app.config.FilePattern)
+		app.afterChange(changes)
 	} else if app.config.Verbose {
-		log.Printf("No changes detected")
+		app.logger.Printf("No changes detected")
+	}
+
+	// Failed downloads are retried by the next sync
+	if len(failed) > 0 {
+		atomic.AddInt64(&app.failedDownloads, int64(len(failed)))
+		app.logger.Printf("Warning: %v", failed)
 	}
 
 	return nil
 }
 
+// removeFiles removes local files and the directories they leave empty, returning the files removed
+func (app *ConfsyncApp) removeFiles(filenames []string) []string {
+	var removed []string
+	for _, filename := range filenames {
+		localPath := filepath.Join(app.config.LocalDir, filepath.FromSlash(filename))
+		if err := os.Remove(localPath); err != nil {
+			app.logger.Printf("Error removing %s: %v", localPath, err)
+		} else {
+			removed = append(removed, filename)
+			app.forgetLocalFile(filename)
+			app.removeEmptyParents(localPath)
+			if app.config.Verbose {
+				app.logger.Printf("Removed: %s", filename)
+			}
+		}
+	}
+	return removed
+}
+
+// restoreCacheEntry reverts a file's entry in the new cache to its previous state
+func (app *ConfsyncApp) restoreCacheEntry(newCache map[string]FileEntry, filename string) {
+	if cachedEntry, exists := app.fileCache[filename]; exists {
+		newCache[filename] = cachedEntry
+	} else {
+		delete(newCache, filename)
+	}
+}
+
+// localFileMatches reports whether the local copy of an entry already has the expected SHA-256
+// digest, and if so records its local state as if confsync had written it
+func (app *ConfsyncApp) localFileMatches(entry FileEntry) bool {
+	info, err := statLocalFile(filepath.Join(app.config.LocalDir, filepath.FromSlash(entry.Name)))
+	if err != nil || info.SHA256 != entry.SHA256 {
+		return false
+	}
+	app.recordLocalFile(entry.Name, info)
+	return true
+}
+
+// entryChanged reports whether a remote entry differs from its cached version. Entries
+// from a manifest are compared by SHA-256 digest, entries carrying an ETag (such as
+// S3 objects) by ETag and modification time, all others by modification time and size.
+// An entry now taken from another overlay source has always changed.
+func entryChanged(cached, entry FileEntry) bool {
+	if cached.Source != entry.Source {
+		return true
+	}
+	if cached.SHA256 != "" || entry.SHA256 != "" {
+		return cached.SHA256 != entry.SHA256
+	}
+	if cached.ETag != "" || entry.ETag != "" {
+		return cached.ETag != entry.ETag || cached.MTime != entry.MTime
+	}
+	return cached.MTime != entry.MTime || cached.Size != entry.Size
+}
+
+// scanLocalFiles walks the local directory up to the configured max depth and
+// returns the slash-separated paths of all regular files relative to it
+func (app *ConfsyncApp) scanLocalFiles() ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(app.config.LocalDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(app.config.LocalDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if d.IsDir() {
+			if relPath == "." {
+				return nil
+			}
+			if app.isInternalFile(relPath) {
+				return filepath.SkipDir
+			}
+			depth := strings.Count(relPath, "/") + 1
+			if app.config.MaxDepth >= 0 && depth > app.config.MaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type().IsRegular() && !app.isInternalFile(relPath) {
+			files = append(files, relPath)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// removeEmptyParents removes directories left empty by a file removal, stopping at the local directory
+func (app *ConfsyncApp) removeEmptyParents(localPath string) {
+	root := filepath.Clean(app.config.LocalDir)
+	for dir := filepath.Dir(localPath); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// os.Remove refuses to delete non-empty directories, which ends the walk
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
 // setLastError safely sets the last error message
 func (app *ConfsyncApp) setLastError(err string) {
 	app.mu.Lock()
@@ -385,7 +1168,7 @@ func (app *ConfsyncApp) getHealthStatus() HealthStatus {
 	status := "healthy"
 	if app.lastError != "" {
 		// Consider unhealthy if last error was recent (within 3 sync intervals)
-		errorThreshold := time.Now().Add(-3 * app.config.PollInterval)
+		errorThreshold := time.Now().Add(-3 * app.syncInterval())
 		if app.lastSync.Before(errorThreshold) {
 			status = "unhealthy"
 		} else {
@@ -394,27 +1177,70 @@ func (app *ConfsyncApp) getHealthStatus() HealthStatus {
 	}
 
 	return HealthStatus{
-		Status:        status,
-		Timestamp:     time.Now(),
-		LastSync:      app.lastSync,
-		LastError:     app.lastError,
-		SyncedFiles:   atomic.LoadInt64(&app.syncedFiles),
-		TotalRequests: atomic.LoadInt64(&app.totalReqs),
-		FailedSyncs:   atomic.LoadInt64(&app.failedSyncs),
-		Uptime:        time.Since(app.startTime),
+		Status:          status,
+		Timestamp:       time.Now(),
+		LastSync:        app.lastSync,
+		LastError:       app.lastError,
+		SyncedFiles:     atomic.LoadInt64(&app.syncedFiles),
+		TotalRequests:   atomic.LoadInt64(&app.totalReqs),
+		FailedSyncs:     atomic.LoadInt64(&app.failedSyncs),
+		FailedDownloads: atomic.LoadInt64(&app.failedDownloads),
+		HookFailures:    atomic.LoadInt64(&app.hookFailures),
+		DriftedFiles:    atomic.LoadInt64(&app.driftedFiles),
+		Pinned:          app.pinned,
+		LastHook:        app.lastHook,
+		SignalsSent:     app.signalsSent(),
+		Watching:        app.watchConnected(),
+		Frozen:          app.frozen(time.Now()),
+		Deferred:        app.deferred,
+		Breaker:         app.breaker.currentState(),
+		Mirror:          app.activeMirror(),
+		FileSources:     app.fileSources,
+		Uptime:          time.Since(app.startTime),
 		Config: map[string]string{
 			"remote_url":       app.config.RemoteURL,
+			"mirrors":          app.mirrorDescription(),
+			"overlays":         app.overlayDescription(),
+			"source":           app.config.SourceType,
+			"manifest":         app.config.Manifest,
+			"signature_format": app.signatureFormat(),
 			"local_dir":        app.config.LocalDir,
+			"state_file":       app.statePath(),
+			"snapshot":         fmt.Sprintf("%t", app.config.Snapshot),
+			"transactional":    fmt.Sprintf("%t", app.config.Transactional),
+			"history":          fmt.Sprintf("%d", app.config.History),
+			"hook":             app.config.Hook,
+			"signal_target":    app.signalTarget(),
+			"webhooks":         fmt.Sprintf("%d", app.webhookCount()),
+			"watch":            app.watchDescription(),
+			"drift_check":      app.config.DriftCheck,
+			"drift_action":     app.config.DriftAction,
 			"file_pattern":     app.config.FilePattern,
 			"poll_interval":    app.config.PollInterval.String(),
+			"schedule":         app.config.Schedule,
+			"jitter":           app.config.Jitter.String(),
+			"freeze":           app.config.Freeze,
 			"connect_timeout":  app.config.ConnectTimeout.String(),
 			"download_timeout": app.config.DownloadTimeout.String(),
 			"max_retries":      fmt.Sprintf("%d", app.config.MaxRetries),
 			"retry_delay":      app.config.RetryDelay.String(),
+			"breaker":          app.breakerDescription(),
+			"max_depth":        fmt.Sprintf("%d", app.config.MaxDepth),
+			"concurrency":      fmt.Sprintf("%d", app.config.Concurrency),
+			"max_bandwidth":    app.config.MaxBandwidth,
+			"listing_format":   app.config.ListingFormat,
 		},
 	}
 }
 
+// signatureFormat returns the configured signature format, or "disabled" when signatures aren't verified
+func (app *ConfsyncApp) signatureFormat() string {
+	if app.verifier == nil {
+		return "disabled"
+	}
+	return app.verifier.format
+}
+
 // healthHandler handles health check requests
 func (app *ConfsyncApp) healthHandler(w http.ResponseWriter, r *http.Request) {
 	health := app.getHealthStatus()
@@ -432,44 +1258,54 @@ func (app *ConfsyncApp) healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewEncoder(w).Encode(health); err != nil {
-		log.Printf("Failed to encode health response: %v", err)
+		app.logger.Printf("Failed to encode health response: %v", err)
 	}
 }
 
 // readinessHandler handles readiness check requests
 func (app *ConfsyncApp) readinessHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if we can reach the remote URL
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	writeReadiness(w, app.checkReady(r.Context()))
+}
+
+// checkReady checks if we can reach the remote URL and every overlay source
+func (app *ConfsyncApp) checkReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "HEAD", app.config.RemoteURL, nil)
-	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if err := json.NewEncoder(w).Encode(map[string]string{
-			"status": "not ready",
-			"error":  "failed to create request",
-		}); err != nil {
-			log.Printf("Failed to encode readiness response: %v", err)
+	for i, base := range append([]string{app.baseURL()}, app.overlays...) {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", base, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request")
 		}
-		return
-	}
 
-	req.Header.Set("User-Agent", app.config.UserAgent)
+		req.Header.Set("User-Agent", app.config.UserAgent)
 
-	resp, err := app.listingClient.Do(req)
+		resp, err := app.listingClient.Do(req)
+		if err != nil {
+			if i > 0 {
+				return fmt.Errorf("overlay source %s unreachable", redactURL(base))
+			}
+			return fmt.Errorf("remote server unreachable")
+		}
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			app.logger.Printf("Failed to close response body: %v", closeErr)
+		}
+	}
+	return nil
+}
+
+// writeReadiness writes the response of a readiness check that failed with err, or succeeded if err is nil
+func writeReadiness(w http.ResponseWriter, err error) {
 	if err != nil {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		if err := json.NewEncoder(w).Encode(map[string]string{
 			"status": "not ready",
-			"error":  "remote server unreachable",
+			"error":  err.Error(),
 		}); err != nil {
 			log.Printf("Failed to encode readiness response: %v", err)
 		}
 		return
 	}
-	if closeErr := resp.Body.Close(); closeErr != nil {
-		log.Printf("Failed to close response body: %v", closeErr)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -491,33 +1327,13 @@ func (app *ConfsyncApp) startHealthServer() error {
 	mux.HandleFunc("/health/live", app.healthHandler)
 	mux.HandleFunc("/health/ready", app.readinessHandler)
 
-	// Simple metrics endpoint
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		health := app.getHealthStatus()
-		w.Header().Set("Content-Type", "text/plain")
-
-		metrics := []string{
-			"# HELP confsync_synced_files_total Total number of synced files\n",
-			"# TYPE confsync_synced_files_total counter\n",
-			fmt.Sprintf("confsync_synced_files_total %d\n", health.SyncedFiles),
-			"# HELP confsync_requests_total Total number of requests to remote server\n",
-			"# TYPE confsync_requests_total counter\n",
-			fmt.Sprintf("confsync_requests_total %d\n", health.TotalRequests),
-			"# HELP confsync_failed_syncs_total Total number of failed sync attempts\n",
-			"# TYPE confsync_failed_syncs_total counter\n",
-			fmt.Sprintf("confsync_failed_syncs_total %d\n", health.FailedSyncs),
-			"# HELP confsync_uptime_seconds Uptime in seconds\n",
-			"# TYPE confsync_uptime_seconds gauge\n",
-			fmt.Sprintf("confsync_uptime_seconds %f\n", health.Uptime.Seconds()),
-		}
-
-		for _, metric := range metrics {
-			if _, err := fmt.Fprint(w, metric); err != nil {
-				log.Printf("Failed to write metric: %v", err)
-				return
-			}
-		}
-	})
+	// Admin endpoints change the local directory, so they only exist with a token
+	if app.adminToken != "" {
+		mux.HandleFunc("/admin/rollback", app.adminRollbackHandler)
+		mux.HandleFunc("/sync", app.syncHandler)
+	}
+
+	mux.HandleFunc("/metrics", app.metricsHandler)
 
 	app.healthServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", app.config.HealthPort),
@@ -525,75 +1341,152 @@ func (app *ConfsyncApp) startHealthServer() error {
 	}
 
 	go func() {
-		log.Printf("Health server starting on port %d", app.config.HealthPort)
+		app.logger.Printf("Health server starting on port %d", app.config.HealthPort)
 		if err := app.healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Health server error: %v", err)
+			app.logger.Printf("Health server error: %v", err)
 		}
 	}()
 
 	return nil
 }
 
-// Run starts the synchronization loop
+// runSync runs a sync, records a failure and notifies the webhooks of failures and health transitions.
+// While the circuit breaker is open, the sync is a probe of the remote.
+func (app *ConfsyncApp) runSync(description string) error {
+	app.breaker.begin()
+	err := app.syncFiles()
+	app.breaker.record(err)
+	if err != nil {
+		app.logger.Printf("%s failed: %v", description, err)
+		app.setLastError(fmt.Sprintf("%s failed: %v", description, err))
+		app.syncFailed(err)
+	}
+	app.checkHealthTransition()
+	return err
+}
+
+// Run starts the health server and the synchronization loop, until the process receives SIGINT or SIGTERM
 func (app *ConfsyncApp) Run() {
-	log.Printf("Starting confsync")
-	log.Printf("Remote URL: %s", app.config.RemoteURL)
-	log.Printf("Local directory: %s", app.config.LocalDir)
-	log.Printf("File pattern: %s", app.config.FilePattern)
-	log.Printf("Poll interval: %v", app.config.PollInterval)
+	app.logger.Printf("Starting confsync")
+	app.logger.Printf("Remote URL: %s", app.config.RemoteURL)
+	app.logger.Printf("Local directory: %s", app.config.LocalDir)
+	app.logger.Printf("File pattern: %s", app.config.FilePattern)
+	if app.schedule != nil {
+		app.logger.Printf("Schedule: %s", app.config.Schedule)
+	} else {
+		app.logger.Printf("Poll interval: %v", app.config.PollInterval)
+	}
+	if app.config.MaxDepth != 0 {
+		app.logger.Printf("Max depth: %d", app.config.MaxDepth)
+	}
 
 	// Ensure local directory exists
 	if err := os.MkdirAll(app.config.LocalDir, 0755); err != nil {
-		log.Fatalf("Failed to create local directory %s: %v", app.config.LocalDir, err)
+		app.logger.Fatalf("Failed to create local directory %s: %v", app.config.LocalDir, err)
 	}
 
 	// Start health server
 	if err := app.startHealthServer(); err != nil {
-		log.Fatalf("Failed to start health server: %v", err)
+		app.logger.Fatalf("Failed to start health server: %v", err)
 	}
 
 	// Set up signal handling for graceful shutdown
+	sig, stop := notifyShutdown()
+	app.syncLoop(stop)
+	app.shutdown(*sig)
+}
+
+// notifyShutdown returns a channel closed when the process receives SIGINT or SIGTERM, and where the
+// signal is stored before that
+func notifyShutdown() (*os.Signal, <-chan struct{}) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	var sig os.Signal
+	stop := make(chan struct{})
+	go func() {
+		sig = <-sigChan
+		close(stop)
+	}()
+	return &sig, stop
+}
+
+// syncLoop runs the initial sync and the scheduled syncs until stop is closed
+func (app *ConfsyncApp) syncLoop(stop <-chan struct{}) {
+	// Spread the initial syncs of instances started together
+	if delay := randomDuration(app.config.Splay); delay > 0 {
+		app.logger.Printf("Delaying initial sync by %v", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+	}
+
 	// Initial sync
-	if err := app.syncFiles(); err != nil {
-		log.Printf("Initial sync failed: %v", err)
-		app.setLastError(fmt.Sprintf("Initial sync failed: %v", err))
+	app.runSync("Initial sync")
+
+	// Announced revisions trigger a sync; polling only continues while the watch connection is down.
+	// The watcher gets its own context, since every sync replaces the download context.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if app.watcher != nil {
+		app.logger.Printf("Watching %s (%s)", redactURL(app.watcher.url), app.watcher.mode)
+		go app.watcher.run(watchCtx, func() { app.triggerSync() })
 	}
 
-	// Start polling loop
-	ticker := time.NewTicker(app.config.PollInterval)
-	defer ticker.Stop()
+	// Start polling loop, waiting for the interval or the next cron match after every sync
+	timer := time.NewTimer(app.nextSyncDelay(time.Now()))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := app.syncFiles(); err != nil {
-				log.Printf("Sync failed: %v", err)
-				app.setLastError(fmt.Sprintf("Sync failed: %v", err))
-			}
-		case sig := <-sigChan:
-			log.Printf("Received signal %v, shutting down gracefully...", sig)
-
-			// Cancel any ongoing downloads
-			app.downloadCancel()
-
-			// Shutdown health server
-			if app.healthServer != nil {
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-				if err := app.healthServer.Shutdown(ctx); err != nil {
-					log.Printf("Health server shutdown error: %v", err)
-				}
+		case <-timer.C:
+			if !app.watchConnected() {
+				app.runSync("Sync")
 			}
-
-			log.Printf("Shutdown complete")
+			timer.Reset(app.nextSyncDelay(time.Now()))
+		case <-stop:
 			return
 		}
 	}
 }
 
+// shutdown cancels ongoing work and stops the health server
+func (app *ConfsyncApp) shutdown(sig os.Signal) {
+	app.logger.Printf("Received signal %v, shutting down gracefully...", sig)
+
+	app.drain()
+	shutdownHealthServer(app.healthServer)
+
+	app.logger.Printf("Shutdown complete")
+}
+
+// drain cancels any ongoing downloads, waits for triggered syncs to end, sends a pending debounced
+// signal and gives pending webhook deliveries a chance to finish
+func (app *ConfsyncApp) drain() {
+	app.shutdownCancel()
+
+	// No sync is triggered once the shutdown context is cancelled, so none is added while waiting
+	app.triggerMu.Lock()
+	app.triggerMu.Unlock()
+	app.triggeredSyncs.Wait()
+
+	app.flushNotifications()
+}
+
+// shutdownHealthServer stops a health server, if there is one
+func shutdownHealthServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Health server shutdown error: %v", err)
+	}
+}
+
 // parseFlags parses command line flags and environment variables using struct tags
 func parseFlags() Config {
 	var config Config
@@ -703,8 +1596,22 @@ func parseFlags() Config {
 
 func main() {
 	config := parseFlags()
+	command := flag.Args()
 
-	if config.RemoteURL == "" {
+	if config.Jobs != "" {
+		if len(command) > 0 {
+			log.Fatal("Commands can't be used with -jobs, run them with the -dir of a job instead")
+		}
+		runner, err := newJobRunner(config)
+		if err != nil {
+			log.Fatalf("Failed to create jobs: %v", err)
+		}
+		runner.Run()
+		return
+	}
+
+	// Commands work on the local directory only
+	if config.RemoteURL == "" && len(command) == 0 {
 		log.Fatal("Remote URL is required. Use -url flag or CONFSYNC_URL environment variable")
 	}
 
@@ -717,5 +1624,15 @@ func main() {
 		log.Fatalf("Failed to create application: %v", err)
 	}
 
+	if len(command) > 0 {
+		if command[0] != "rollback" {
+			log.Fatalf("Unknown command %q", command[0])
+		}
+		if err := app.runRollbackCommand(command[1:]); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		return
+	}
+
 	app.Run()
 }
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -220,3 +221,106 @@ func TestConfigurationParsing(t *testing.T) {
 		})
 	}
 }
+
+func TestRecursiveSync(t *testing.T) {
+	listings := map[string]string{
+		"/": `[
+			{"name": "top.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 3},
+			{"name": "ns", "type": "directory", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT"}
+		]`,
+		"/ns/": `[
+			{"name": "app.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 3},
+			{"name": "deep", "type": "directory", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT"}
+		]`,
+		"/ns/deep/": `[
+			{"name": "skipped.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 3}
+		]`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if listing, ok := listings[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, listing)
+			return
+		}
+		fmt.Fprint(w, "abc")
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	staleDir := filepath.Join(localDir, "old")
+	if err := os.MkdirAll(staleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staleDir, "stale.yaml"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `^(.*/)?[^/]+\.yaml$`,
+		MaxDepth:    1,
+		DeleteFiles: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	for _, name := range []string{"top.yaml", "ns/app.yaml"} {
+		if _, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s to be synced: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(localDir, "ns", "deep")); !os.IsNotExist(err) {
+		t.Errorf("Expected directory beyond max depth to be skipped")
+	}
+
+	if _, err := os.Stat(staleDir); !os.IsNotExist(err) {
+		t.Errorf("Expected stale nested file and its empty directory to be removed")
+	}
+}
+
+func TestRecursiveSyncDirectoryCycle(t *testing.T) {
+	// Every directory contains itself, like a symlink to a parent served by nginx
+	var listings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			atomic.AddInt32(&listings, 1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[
+				{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 3},
+				{"name": "loop", "type": "directory", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT"}
+			]`)
+			return
+		}
+		fmt.Fprint(w, "abc")
+	}))
+	defer server.Close()
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    t.TempDir(),
+		FilePattern: `^(.*/)?[^/]+\.yaml$`,
+		MaxDepth:    -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	entries, err := app.fetchRemoteTree(app.config.RemoteURL)
+	if err != nil {
+		t.Fatalf("Failed to fetch tree: %v", err)
+	}
+	if got := atomic.LoadInt32(&listings); got != maxListingDepth+1 {
+		t.Errorf("Expected %d listings before giving up on the cycle, got %d", maxListingDepth+1, got)
+	}
+	if len(entries) != maxListingDepth+1 {
+		t.Errorf("Expected %d files, got %d", maxListingDepth+1, len(entries))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// manifest is the document served in manifest mode. It may also be served as a bare array of files.
+type manifest struct {
+	Files []FileEntry `json:"files"`
+}
+
+// parseManifest parses a JSON manifest listing name, size and sha256 for every file.
+// Names may be nested slash-separated paths relative to the remote URL.
+func parseManifest(body []byte) ([]FileEntry, error) {
+	var entries []FileEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		var doc manifest
+		if docErr := json.Unmarshal(body, &doc); docErr != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		entries = doc.Files
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		if !isSafeRelPath(entry.Name) {
+			return nil, fmt.Errorf("manifest entry %d has unsafe name %q", i, entry.Name)
+		}
+		if seen[entry.Name] {
+			return nil, fmt.Errorf("manifest lists %s more than once", entry.Name)
+		}
+		seen[entry.Name] = true
+
+		entry.SHA256 = strings.ToLower(entry.SHA256)
+		if decoded, err := hex.DecodeString(entry.SHA256); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("manifest entry %s has invalid sha256 %q", entry.Name, entry.SHA256)
+		}
+		if entry.Type == "" {
+			entry.Type = "file"
+		}
+	}
+
+	return entries, nil
+}
+
+// fetchManifest fetches and parses the manifest relative to a base URL, with the usual retry logic
+func (app *ConfsyncApp) fetchManifest(base string) ([]FileEntry, error) {
+	atomic.AddInt64(&app.totalReqs, 1)
+
+	var entries []FileEntry
+	var manifestURL string
+
+	err := app.fetchWithRetries(func() (*http.Request, error) {
+		req, err := app.newFileRequest(context.Background(), base, app.config.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		app.addConditionalHeaders(req)
+		manifestURL = req.URL.String()
+		return req, nil
+	}, func(resp *http.Response, body []byte) error {
+		// The manifest was verified and parsed when it was first fetched
+		if resp.StatusCode == http.StatusNotModified {
+			cached, ok := app.cachedListing(manifestURL)
+			if !ok {
+				app.forgetValidators(manifestURL)
+				return fmt.Errorf("server returned 304 for a manifest that is not cached")
+			}
+			entries = cached
+			return nil
+		}
+
+		if app.verifier != nil {
+			if err := app.verifyDetachedSignature(body, func() (*http.Request, error) {
+				return app.newFileRequest(context.Background(), base, app.config.Manifest+app.verifier.suffix())
+			}); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if entries, err = parseManifest(body); err != nil {
+			return err
+		}
+
+		app.rememberValidators(manifestURL, resp.Header, append([]FileEntry{}, entries...))
+		return nil
+	})
+	if err != nil {
+		if !app.canFailOver() {
+			app.setLastError(fmt.Sprintf("failed after %d retries: %v", app.config.MaxRetries, err))
+		}
+		return nil, fmt.Errorf("failed after %d retries: %w", app.config.MaxRetries, err)
+	}
+
+	return entries, nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 digest of a local file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Printf("Failed to close %s: %v", path, closeErr)
+		}
+	}()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
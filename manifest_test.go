@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseManifest(t *testing.T) {
+	digest := sha256Hex("a")
+
+	entries, err := parseManifest([]byte(`{"files": [{"name": "ns/a.yaml", "size": 1, "sha256": "` + strings.ToUpper(digest) + `"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "ns/a.yaml" || entries[0].Type != "file" || entries[0].SHA256 != digest {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+
+	invalid := []string{
+		`[{"name": "a.yaml", "size": 1, "sha256": "abc"}]`,
+		`[{"name": "../a.yaml", "size": 1, "sha256": "` + digest + `"}]`,
+		`[{"name": "a.yaml", "sha256": "` + digest + `"}, {"name": "a.yaml", "sha256": "` + digest + `"}]`,
+		`not json`,
+	}
+	for _, body := range invalid {
+		if _, err := parseManifest([]byte(body)); err == nil {
+			t.Errorf("Expected manifest to be rejected: %s", body)
+		}
+	}
+}
+
+func TestManifestSync(t *testing.T) {
+	files := map[string]string{
+		"good.yaml":      "good: true\n",
+		"ns/nested.yaml": "nested: true\n",
+		"tampered.yaml":  "evil: true\n",
+		"existing.yaml":  "existing: true\n",
+	}
+	manifest := fmt.Sprintf(`[
+		{"name": "good.yaml", "size": 11, "sha256": "%s"},
+		{"name": "ns/nested.yaml", "size": 13, "sha256": "%s"},
+		{"name": "tampered.yaml", "size": 11, "sha256": "%s"},
+		{"name": "existing.yaml", "size": 15, "sha256": "%s"}
+	]`, sha256Hex(files["good.yaml"]), sha256Hex(files["ns/nested.yaml"]), sha256Hex("expected: true\n"), sha256Hex(files["existing.yaml"]))
+
+	var downloads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == "manifest.json" {
+			fmt.Fprint(w, manifest)
+			return
+		}
+		content, ok := files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		downloads = append(downloads, name)
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(localDir, "existing.yaml"), []byte(files["existing.yaml"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "tampered.yaml"), []byte("previous: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL,
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+		Manifest:    "manifest.json",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	for _, name := range []string{"good.yaml", "ns/nested.yaml"} {
+		content, err := os.ReadFile(filepath.Join(localDir, filepath.FromSlash(name)))
+		if err != nil || string(content) != files[name] {
+			t.Errorf("Expected %s to be installed, got %q (%v)", name, content, err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(localDir, "tampered.yaml"))
+	if err != nil || string(content) != "previous: true\n" {
+		t.Errorf("Expected file with mismatching digest not to be installed, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(app.partialPath("tampered.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary file to be cleaned up")
+	}
+	if _, cached := app.fileCache["tampered.yaml"]; cached {
+		t.Errorf("Expected file with mismatching digest not to be cached")
+	}
+
+	for _, name := range downloads {
+		if name == "existing.yaml" {
+			t.Errorf("Expected matching local file not to be downloaded")
+		}
+	}
+}
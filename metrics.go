@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// metricSample is a sample of a metric, with optional labels such as state="open"
+type metricSample struct {
+	labels string
+	value  string
+}
+
+// metricFamily is a metric with its samples, written in the Prometheus text format
+type metricFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []metricSample
+}
+
+// newMetric creates a metric with a single unlabeled sample
+func newMetric(name, kind, help, value string) metricFamily {
+	return metricFamily{name: name, kind: kind, help: help, samples: []metricSample{{value: value}}}
+}
+
+// metricFamilies returns the metrics of the application
+func (app *ConfsyncApp) metricFamilies() []metricFamily {
+	health := app.getHealthStatus()
+
+	families := []metricFamily{
+		newMetric("confsync_synced_files_total", "counter", "Total number of synced files", fmt.Sprintf("%d", health.SyncedFiles)),
+		newMetric("confsync_requests_total", "counter", "Total number of requests to remote server", fmt.Sprintf("%d", health.TotalRequests)),
+		newMetric("confsync_failed_syncs_total", "counter", "Total number of failed sync attempts", fmt.Sprintf("%d", health.FailedSyncs)),
+		newMetric("confsync_failed_downloads_total", "counter", "Total number of failed file downloads", fmt.Sprintf("%d", health.FailedDownloads)),
+		newMetric("confsync_hook_failures_total", "counter", "Total number of failed hook runs", fmt.Sprintf("%d", health.HookFailures)),
+		newMetric("confsync_signals_sent_total", "counter", "Total number of signals sent to the target process", fmt.Sprintf("%d", health.SignalsSent)),
+		newMetric("confsync_drifted_files", "gauge", "Number of synced files found modified or removed locally in the last sync", fmt.Sprintf("%d", health.DriftedFiles)),
+		newMetric("confsync_watching", "gauge", "Whether the watch connection is established", fmt.Sprintf("%d", boolToInt(health.Watching))),
+		newMetric("confsync_uptime_seconds", "gauge", "Uptime in seconds", fmt.Sprintf("%f", health.Uptime.Seconds())),
+	}
+	families = append(families, app.mirrorMetrics()...)
+	if health.Breaker != "" {
+		families = append(families, breakerStateMetric(health.Breaker))
+	}
+	return families
+}
+
+// withLabel returns a copy of the families with a label added to every sample
+func withLabel(families []metricFamily, label string) []metricFamily {
+	labeled := make([]metricFamily, len(families))
+	for i, family := range families {
+		labeled[i] = family
+		labeled[i].samples = make([]metricSample, len(family.samples))
+		for j, sample := range family.samples {
+			labels := label
+			if sample.labels != "" {
+				labels += "," + sample.labels
+			}
+			labeled[i].samples[j] = metricSample{labels: labels, value: sample.value}
+		}
+	}
+	return labeled
+}
+
+// mergeMetricFamilies merges the samples of families with the same name, in the order the families first appear
+func mergeMetricFamilies(groups ...[]metricFamily) []metricFamily {
+	var merged []metricFamily
+	index := make(map[string]int)
+	for _, families := range groups {
+		for _, family := range families {
+			i, ok := index[family.name]
+			if !ok {
+				index[family.name] = len(merged)
+				family.samples = append([]metricSample{}, family.samples...)
+				merged = append(merged, family)
+				continue
+			}
+			merged[i].samples = append(merged[i].samples, family.samples...)
+		}
+	}
+	return merged
+}
+
+// writeMetrics writes the families in the Prometheus text format
+func writeMetrics(w io.Writer, families []metricFamily) {
+	for _, family := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind); err != nil {
+			log.Printf("Failed to write metric: %v", err)
+			return
+		}
+		for _, sample := range family.samples {
+			name := family.name
+			if sample.labels != "" {
+				name += "{" + sample.labels + "}"
+			}
+			if _, err := fmt.Fprintf(w, "%s %s\n", name, sample.value); err != nil {
+				log.Printf("Failed to write metric: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func (app *ConfsyncApp) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	writeMetrics(w, app.metricFamilies())
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mirror is a remote base URL serving the same content as the others
+type mirror struct {
+	url       string
+	successes int64
+	errors    int64
+	failedAt  time.Time
+}
+
+// mirrorSet is the ordered list of remote base URLs, the primary first. A mirror that failed is
+// skipped for the recheck interval, after which it is preferred again according to its position.
+type mirrorSet struct {
+	mu       sync.Mutex
+	mirrors  []*mirror
+	active   int
+	failover bool
+	recheck  time.Duration
+	logger   *log.Logger
+}
+
+// newMirrorSet creates the mirror set of the primary URL and the comma-separated fallback URLs, or
+// returns nil if there are no fallbacks
+func newMirrorSet(primary, fallbacks string, recheck time.Duration, logger *log.Logger) (*mirrorSet, error) {
+	set := &mirrorSet{mirrors: []*mirror{{url: primary}}, recheck: recheck, logger: logger}
+	for _, raw := range strings.Split(fallbacks, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid mirror URL %q: must be an absolute http(s) URL", redactURL(raw))
+		}
+		set.mirrors = append(set.mirrors, &mirror{url: raw})
+	}
+	if len(set.mirrors) == 1 {
+		return nil, nil
+	}
+	return set, nil
+}
+
+// order returns the indexes of the mirrors to try: the ones that haven't failed within the recheck
+// interval in their configured order, then the others, least recently failed first
+func (s *mirrorSet) order(now time.Time) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var healthy, failed []int
+	for i, m := range s.mirrors {
+		if !m.failedAt.IsZero() && now.Sub(m.failedAt) < s.recheck {
+			failed = append(failed, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	sort.SliceStable(failed, func(a, b int) bool {
+		return s.mirrors[failed[a]].failedAt.Before(s.mirrors[failed[b]].failedAt)
+	})
+	return append(healthy, failed...)
+}
+
+// use makes a mirror the one serving the current sync. failover tells whether other mirrors are left to try.
+func (s *mirrorSet) use(i int, failover bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i != s.active {
+		if i == 0 {
+			s.logger.Printf("Switching back to the primary remote %s", redactURL(s.mirrors[i].url))
+		} else {
+			s.logger.Printf("Failing over to mirror %s", redactURL(s.mirrors[i].url))
+		}
+	}
+	s.active = i
+	s.failover = failover
+}
+
+// succeeded records a successful request to a mirror, which is then no longer skipped
+func (s *mirrorSet) succeeded(i int) {
+	atomic.AddInt64(&s.mirrors[i].successes, 1)
+	s.mu.Lock()
+	s.mirrors[i].failedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// failed records a failed request to a mirror, which is then skipped for the recheck interval
+func (s *mirrorSet) failed(i int, now time.Time) {
+	atomic.AddInt64(&s.mirrors[i].errors, 1)
+	s.mu.Lock()
+	s.mirrors[i].failedAt = now
+	s.mu.Unlock()
+}
+
+// url returns the base URL of a mirror
+func (s *mirrorSet) url(i int) string {
+	return s.mirrors[i].url
+}
+
+// activeIndex returns the index of the mirror serving the current sync
+func (s *mirrorSet) activeIndex() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// canFailOver reports whether the mirror serving the current sync isn't the last one left to try
+func (s *mirrorSet) canFailOver() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failover
+}
+
+// canFailOver reports whether a failing request of the current sync has other mirrors left to try
+func (app *ConfsyncApp) canFailOver() bool {
+	return app.mirrors != nil && app.mirrors.canFailOver()
+}
+
+// baseURL returns the base URL of the mirror serving the current sync
+func (app *ConfsyncApp) baseURL() string {
+	if app.mirrors == nil {
+		return app.config.RemoteURL
+	}
+	return app.mirrors.url(app.mirrors.activeIndex())
+}
+
+// fetchFromMirrors fetches the remote tree from the first mirror that serves it. Each mirror but the
+// last makes a single attempt, so that an outage fails over instead of waiting for retries.
+func (app *ConfsyncApp) fetchFromMirrors() ([]FileEntry, error) {
+	if app.mirrors == nil {
+		return app.fetchRemoteTree(app.config.RemoteURL)
+	}
+
+	order := app.mirrors.order(time.Now())
+	var errs []error
+	for n, i := range order {
+		app.mirrors.use(i, n < len(order)-1)
+		entries, err := app.fetchRemoteTree(app.mirrors.url(i))
+		if err == nil {
+			app.mirrors.succeeded(i)
+			// Requests to overlay sources that follow have no mirrors to fail over to
+			app.mirrors.use(i, false)
+			return entries, nil
+		}
+		app.mirrors.failed(i, time.Now())
+		app.logger.Printf("Mirror %s failed: %v", redactURL(app.mirrors.url(i)), err)
+		errs = append(errs, fmt.Errorf("%s: %w", redactURL(app.mirrors.url(i)), err))
+	}
+	return nil, fmt.Errorf("all mirrors failed: %w", errors.Join(errs...))
+}
+
+// downloadFromMirrors downloads a file from the mirror serving the current sync, failing over to the
+// others if that fails. Each mirror but the last makes a single attempt.
+func (app *ConfsyncApp) downloadFromMirrors(entry FileEntry, root string) error {
+	if app.mirrors == nil {
+		return app.downloadWithRetries(entry, root, app.baseURL(), app.config.MaxRetries)
+	}
+
+	active := app.mirrors.activeIndex()
+	order := []int{active}
+	for _, i := range app.mirrors.order(time.Now()) {
+		if i != active {
+			order = append(order, i)
+		}
+	}
+
+	var err error
+	for n, i := range order {
+		maxRetries := 0
+		if n == len(order)-1 {
+			maxRetries = app.config.MaxRetries
+		}
+		err = app.downloadWithRetries(entry, root, app.mirrors.url(i), maxRetries)
+		if err == nil || errors.Is(err, errNotModified) {
+			app.mirrors.succeeded(i)
+			return err
+		}
+		if app.downloadCtx.Err() != nil {
+			return err
+		}
+		app.mirrors.failed(i, time.Now())
+		if n < len(order)-1 {
+			app.logger.Printf("Download of %s from mirror %s failed, trying the next mirror: %v", entry.Name, redactURL(app.mirrors.url(i)), err)
+		}
+	}
+	return err
+}
+
+// forgetFileValidators forgets the conditional request validators of a file on every mirror and overlay
+func (app *ConfsyncApp) forgetFileValidators(relPath string) {
+	for _, overlay := range app.overlays {
+		app.forgetValidators(mirrorURL(overlay, relPath))
+	}
+	if app.mirrors == nil {
+		app.forgetValidators(app.fileURL(relPath))
+		return
+	}
+	for _, m := range app.mirrors.mirrors {
+		app.forgetValidators(mirrorURL(m.url, relPath))
+	}
+}
+
+// activeMirror returns the mirror serving the current sync for the health endpoint, if there are mirrors
+func (app *ConfsyncApp) activeMirror() string {
+	if app.mirrors == nil {
+		return ""
+	}
+	return redactURL(app.baseURL())
+}
+
+// mirrorDescription lists the fallback mirrors for the health endpoint
+func (app *ConfsyncApp) mirrorDescription() string {
+	if app.mirrors == nil {
+		return ""
+	}
+	var urls []string
+	for _, m := range app.mirrors.mirrors[1:] {
+		urls = append(urls, redactURL(m.url))
+	}
+	return strings.Join(urls, ",")
+}
+
+// mirrorMetrics returns the per-mirror success and error counters
+func (app *ConfsyncApp) mirrorMetrics() []metricFamily {
+	if app.mirrors == nil {
+		return nil
+	}
+	successes := metricFamily{name: "confsync_mirror_successes_total", kind: "counter", help: "Total number of successful listing fetches and downloads per mirror"}
+	failures := metricFamily{name: "confsync_mirror_errors_total", kind: "counter", help: "Total number of failed listing fetches and downloads per mirror"}
+	for _, m := range app.mirrors.mirrors {
+		label := fmt.Sprintf("mirror=%q", redactURL(m.url))
+		successes.samples = append(successes.samples, metricSample{labels: label, value: fmt.Sprintf("%d", atomic.LoadInt64(&m.successes))})
+		failures.samples = append(failures.samples, metricSample{labels: label, value: fmt.Sprintf("%d", atomic.LoadInt64(&m.errors))})
+	}
+	return []metricFamily{successes, failures}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mirrorServer serves a listing with a.yaml, or fails every request while down is set
+func mirrorServer(content string, down *int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if atomic.LoadInt32(down) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`))
+			return
+		}
+		w.Write([]byte(content))
+	}))
+}
+
+func TestMirrorFailover(t *testing.T) {
+	var primaryDown, mirrorDown int32 = 1, 0
+	var primaryRequests, mirrorRequests int32
+	primary := mirrorServer("p\n", &primaryDown, &primaryRequests)
+	defer primary.Close()
+	mirror := mirrorServer("m\n", &mirrorDown, &mirrorRequests)
+	defer mirror.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:     primary.URL + "/",
+		Mirrors:       mirror.URL + "/",
+		MirrorRecheck: time.Hour,
+		LocalDir:      localDir,
+		FilePattern:   `\.yaml$`,
+		MaxRetries:    3,
+		RetryDelay:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if got := atomic.LoadInt32(&primaryRequests); got != 1 {
+		t.Errorf("Expected a single request to the failing primary before failing over, got %d", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "a.yaml")); string(data) != "m\n" {
+		t.Errorf("Expected the file from the mirror, got %q", data)
+	}
+	if health := app.getHealthStatus(); health.Mirror != mirror.URL+"/" || health.FailedSyncs != 0 {
+		t.Errorf("Expected the mirror to be active without failed syncs, got %q and %d", health.Mirror, health.FailedSyncs)
+	}
+
+	// The failed primary is skipped until the recheck interval passed
+	atomic.StoreInt32(&primaryDown, 0)
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if got := atomic.LoadInt32(&primaryRequests); got != 1 {
+		t.Errorf("Expected the primary to be skipped, got %d requests", got)
+	}
+
+	app.mirrors.recheck = 0
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if health := app.getHealthStatus(); health.Mirror != primary.URL+"/" {
+		t.Errorf("Expected the recovered primary to be active again, got %q", health.Mirror)
+	}
+
+	var buf strings.Builder
+	writeMetrics(&buf, app.mirrorMetrics())
+	metrics := buf.String()
+	for _, want := range []string{
+		`confsync_mirror_errors_total{mirror="` + primary.URL + `/"} 1`,
+		`confsync_mirror_successes_total{mirror="` + primary.URL + `/"} 1`,
+		`confsync_mirror_successes_total{mirror="` + mirror.URL + `/"} 3`,
+		`confsync_mirror_errors_total{mirror="` + mirror.URL + `/"} 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestMirrorFailoverFileSources(t *testing.T) {
+	var primaryDown, mirrorDown int32 = 1, 0
+	var requests int32
+	primary := mirrorServer("p\n", &primaryDown, &requests)
+	defer primary.Close()
+	mirror := mirrorServer("m\n", &mirrorDown, &requests)
+	defer mirror.Close()
+	site := httptest.NewServer(&overlaySource{files: map[string]string{"b.yaml": "site b\n"}})
+	defer site.Close()
+
+	config := Config{
+		RemoteURL:     primary.URL + "/",
+		Mirrors:       mirror.URL + "/",
+		MirrorRecheck: time.Hour,
+		Overlay:       site.URL + "/",
+		LocalDir:      t.TempDir(),
+		FilePattern:   `\.yaml$`,
+		RetryDelay:    time.Millisecond,
+		StateFile:     "state.json",
+	}
+	app, err := NewConfsyncApp(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Files of the remote are attributed to the mirror that served the listing, also after a restart
+	if source := app.getHealthStatus().FileSources["a.yaml"]; source != mirror.URL+"/" {
+		t.Errorf("Expected a.yaml to come from the mirror, got %q", source)
+	}
+	restarted, err := NewConfsyncApp(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if source := restarted.getHealthStatus().FileSources["a.yaml"]; source != mirror.URL+"/" {
+		t.Errorf("Expected the restored source of a.yaml to be the mirror, got %q", source)
+	}
+}
+
+func TestMirrorDownloadFailover(t *testing.T) {
+	var mirrorDown int32
+	var primaryRequests, mirrorRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	mirror := mirrorServer("m\n", &mirrorDown, &mirrorRequests)
+	defer mirror.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:     primary.URL + "/",
+		Mirrors:       mirror.URL + "/",
+		MirrorRecheck: time.Hour,
+		LocalDir:      localDir,
+		FilePattern:   `\.yaml$`,
+		MaxRetries:    3,
+		RetryDelay:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "a.yaml")); string(data) != "m\n" {
+		t.Errorf("Expected the file from the mirror, got %q", data)
+	}
+	// One listing request and a single download attempt before failing over
+	if got := atomic.LoadInt32(&primaryRequests); got != 2 {
+		t.Errorf("Expected 2 requests to the primary, got %d", got)
+	}
+	if order := app.mirrors.order(time.Now()); order[0] != 1 {
+		t.Errorf("Expected the primary to be skipped after the failed download, got order %v", order)
+	}
+}
+
+func TestNewMirrorSet(t *testing.T) {
+	set, err := newMirrorSet("https://a.example.com/", " https://b.example.com/ ,http://c.example.com/", time.Minute, log.Default())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(set.mirrors) != 3 || set.url(1) != "https://b.example.com/" {
+		t.Errorf("Unexpected mirrors: %+v", set.mirrors)
+	}
+
+	if set, err := newMirrorSet("https://a.example.com/", "", time.Minute, log.Default()); set != nil || err != nil {
+		t.Errorf("Expected no mirror set without fallbacks, got %v, %v", set, err)
+	}
+	if _, err := newMirrorSet("https://a.example.com/", "ftp://b.example.com/", time.Minute, log.Default()); err == nil {
+		t.Errorf("Expected an error for a non-http mirror")
+	}
+}
+
+func TestMirrorOrder(t *testing.T) {
+	set, _ := newMirrorSet("https://a/", "https://b/,https://c/", time.Minute, log.Default())
+	now := time.Now()
+	set.failed(0, now.Add(-10*time.Second))
+	set.failed(1, now.Add(-20*time.Second))
+
+	order := set.order(now)
+	if len(order) != 3 || order[0] != 2 || order[1] != 1 || order[2] != 0 {
+		t.Errorf("Expected healthy mirrors first, then the least recently failed, got %v", order)
+	}
+	if order := set.order(now.Add(time.Minute)); order[0] != 0 {
+		t.Errorf("Expected the primary to be preferred after the recheck interval, got %v", order)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseOverlays parses the comma-separated overlay source URLs, or returns nil if there are none
+func parseOverlays(raw string) ([]string, error) {
+	var overlays []string
+	for _, overlay := range strings.Split(raw, ",") {
+		if overlay = strings.TrimSpace(overlay); overlay == "" {
+			continue
+		}
+		u, err := url.Parse(overlay)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid overlay URL %q: must be an absolute http(s) URL", redactURL(overlay))
+		}
+		overlays = append(overlays, overlay)
+	}
+	return overlays, nil
+}
+
+// fetchSources fetches the remote tree and merges the trees of the overlay sources over it. A file
+// listed by several sources is taken from the last of them. Any source failing fails the whole fetch,
+// as its files would otherwise be removed locally.
+func (app *ConfsyncApp) fetchSources() ([]FileEntry, error) {
+	entries, err := app.fetchFromMirrors()
+	if err != nil {
+		return nil, err
+	}
+	if len(app.overlays) == 0 {
+		for i := range entries {
+			entries[i].Source = 0
+		}
+		return entries, nil
+	}
+
+	merged := make([]FileEntry, 0, len(entries))
+	index := make(map[string]int)
+	add := func(entries []FileEntry, source int) {
+		for _, entry := range entries {
+			entry.Source = source
+			if i, ok := index[entry.Name]; ok {
+				merged[i] = entry
+				continue
+			}
+			index[entry.Name] = len(merged)
+			merged = append(merged, entry)
+		}
+	}
+
+	add(entries, 0)
+	for i, base := range app.overlays {
+		overlay, err := app.fetchRemoteTree(base)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %w", redactURL(base), err)
+		}
+		add(overlay, i+1)
+	}
+	return merged, nil
+}
+
+// sourceURL returns the base URL of a source: the mirror that served the listing of the cached files
+// for 0, else the overlay at that position
+func (app *ConfsyncApp) sourceURL(source int) (string, error) {
+	if source == 0 {
+		if app.listedFrom != "" {
+			return app.listedFrom, nil
+		}
+		return app.config.RemoteURL, nil
+	}
+	if source < 0 || source > len(app.overlays) {
+		return "", fmt.Errorf("unknown source %d", source)
+	}
+	return app.overlays[source-1], nil
+}
+
+// downloadFromSource downloads a file from the source it was listed by
+func (app *ConfsyncApp) downloadFromSource(entry FileEntry, root string) error {
+	if entry.Source == 0 {
+		return app.downloadFromMirrors(entry, root)
+	}
+	base, err := app.sourceURL(entry.Source)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", entry.Name, err)
+	}
+	return app.downloadWithRetries(entry, root, base, app.config.MaxRetries)
+}
+
+// setFileSources records which source each synced file came from, for the health endpoint
+func (app *ConfsyncApp) setFileSources(cache map[string]FileEntry) {
+	if len(app.overlays) == 0 {
+		return
+	}
+	sources := make(map[string]string, len(cache))
+	for filename, entry := range cache {
+		// Entries restored from a state written with other overlays are fetched again by the next sync
+		if base, err := app.sourceURL(entry.Source); err == nil {
+			sources[filename] = redactURL(base)
+		}
+	}
+
+	app.mu.Lock()
+	app.fileSources = sources
+	app.mu.Unlock()
+}
+
+// overlayDescription lists the overlay sources for the health endpoint
+func (app *ConfsyncApp) overlayDescription() string {
+	var urls []string
+	for _, overlay := range app.overlays {
+		urls = append(urls, redactURL(overlay))
+	}
+	return strings.Join(urls, ",")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// overlaySource serves a listing of its files, which can be changed between syncs
+type overlaySource struct {
+	mu    sync.Mutex
+	files map[string]string
+	down  bool
+}
+
+func (s *overlaySource) set(files map[string]string, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = files
+	s.down = down
+}
+
+func (s *overlaySource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if r.URL.Path == "/" {
+		var names []string
+		for name := range s.files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var entries []string
+		for _, name := range names {
+			entries = append(entries, fmt.Sprintf(`{"name": %q, "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": %d}`, name, len(s.files[name])))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[" + strings.Join(entries, ",") + "]"))
+		return
+	}
+	content, ok := s.files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(content))
+}
+
+func TestOverlaySync(t *testing.T) {
+	global := &overlaySource{files: map[string]string{"a.yaml": "base a\n", "b.yaml": "base b\n"}}
+	site := &overlaySource{files: map[string]string{"b.yaml": "site b\n", "c.yaml": "site c\n"}}
+	globalServer := httptest.NewServer(global)
+	defer globalServer.Close()
+	siteServer := httptest.NewServer(site)
+	defer siteServer.Close()
+
+	localDir := t.TempDir()
+	config := Config{
+		RemoteURL:   globalServer.URL + "/",
+		Overlay:     siteServer.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+		DeleteFiles: true,
+		RetryDelay:  time.Millisecond,
+		StateFile:   "state.json",
+	}
+	app, err := NewConfsyncApp(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	expectFiles := func(want map[string]string) {
+		t.Helper()
+		for name, content := range want {
+			data, err := os.ReadFile(filepath.Join(localDir, name))
+			if content == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be removed, got %q, %v", name, data, err)
+				}
+				continue
+			}
+			if string(data) != content {
+				t.Errorf("Expected %s to contain %q, got %q, %v", name, content, data, err)
+			}
+		}
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	expectFiles(map[string]string{"a.yaml": "base a\n", "b.yaml": "site b\n", "c.yaml": "site c\n"})
+
+	health := app.getHealthStatus()
+	encoded, _ := json.Marshal(health.FileSources)
+	want := fmt.Sprintf(`{"a.yaml":%q,"b.yaml":%q,"c.yaml":%q}`, globalServer.URL+"/", siteServer.URL+"/", siteServer.URL+"/")
+	if string(encoded) != want {
+		t.Errorf("Expected file sources %s, got %s", want, encoded)
+	}
+
+	// The source of every file is restored from the state file
+	restarted, err := NewConfsyncApp(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if encoded, _ := json.Marshal(restarted.getHealthStatus().FileSources); string(encoded) != want {
+		t.Errorf("Expected restored file sources %s, got %s", want, encoded)
+	}
+
+	// A file the overlay no longer overrides is fetched from the remaining source, even with the same metadata
+	site.set(map[string]string{"c.yaml": "site c\n"}, false)
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	expectFiles(map[string]string{"a.yaml": "base a\n", "b.yaml": "base b\n", "c.yaml": "site c\n"})
+
+	// Only files that no source provides any more are removed
+	global.set(map[string]string{"b.yaml": "base b\n", "c.yaml": "base c\n"}, false)
+	site.set(map[string]string{"a.yaml": "site a\n"}, false)
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	expectFiles(map[string]string{"a.yaml": "site a\n", "b.yaml": "base b\n", "c.yaml": "base c\n"})
+
+	global.set(map[string]string{"b.yaml": "base b\n"}, false)
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	expectFiles(map[string]string{"a.yaml": "site a\n", "b.yaml": "base b\n", "c.yaml": ""})
+
+	// A failing overlay fails the sync instead of removing its files
+	site.set(nil, true)
+	if err := app.syncFiles(); err == nil || !strings.Contains(err.Error(), "overlay "+siteServer.URL) {
+		t.Errorf("Expected the sync to fail for the overlay, got %v", err)
+	}
+	expectFiles(map[string]string{"a.yaml": "site a\n", "b.yaml": "base b\n"})
+}
+
+func TestListingSourceIgnored(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2, "source": 2}]`))
+			return
+		}
+		w.Write([]byte("a\n"))
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{RemoteURL: server.URL + "/", LocalDir: localDir, FilePattern: `\.yaml$`})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "a.yaml")); string(data) != "a\n" {
+		t.Errorf("Expected the file from the remote URL, got %q", data)
+	}
+	if entry := app.fileCache["a.yaml"]; entry.Source != 0 {
+		t.Errorf("Expected the source in the listing to be ignored, got %d", entry.Source)
+	}
+}
+
+func TestParseOverlays(t *testing.T) {
+	overlays, err := parseOverlays(" https://a.example.com/ ,http://b.example.com/site/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(overlays) != 2 || overlays[0] != "https://a.example.com/" || overlays[1] != "http://b.example.com/site/" {
+		t.Errorf("Unexpected overlays: %v", overlays)
+	}
+
+	if overlays, err := parseOverlays(""); overlays != nil || err != nil {
+		t.Errorf("Expected no overlays, got %v, %v", overlays, err)
+	}
+	if _, err := parseOverlays("/etc/confsync"); err == nil {
+		t.Errorf("Expected an error for a relative overlay URL")
+	}
+}
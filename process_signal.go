@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// commLength is the length the kernel truncates process names in /proc/<pid>/comm to
+const commLength = 15
+
+// processSignaler signals a process after a sync changed matching files. Signals are debounced
+// so that a burst of changes results in a single signal.
+type processSignaler struct {
+	signal   syscall.Signal
+	pidFile  string
+	process  string
+	pattern  *regexp.Regexp
+	debounce time.Duration
+	procDir  string
+	logger   *log.Logger
+
+	mu    sync.Mutex
+	timer *time.Timer
+	sent  int64
+}
+
+// newProcessSignaler creates a signaler from the configuration, or returns nil when no target is configured
+func newProcessSignaler(config Config, logger *log.Logger) (*processSignaler, error) {
+	if config.SignalPidFile == "" && config.SignalProcess == "" {
+		return nil, nil
+	}
+	if config.SignalPidFile != "" && config.SignalProcess != "" {
+		return nil, fmt.Errorf("signal-pidfile and signal-process are mutually exclusive")
+	}
+
+	sig, err := parseSignal(config.SignalName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pattern *regexp.Regexp
+	if config.SignalPattern != "" {
+		if pattern, err = regexp.Compile(config.SignalPattern); err != nil {
+			return nil, fmt.Errorf("invalid signal pattern regex: %w", err)
+		}
+	}
+
+	return &processSignaler{
+		signal:   sig,
+		pidFile:  config.SignalPidFile,
+		process:  config.SignalProcess,
+		pattern:  pattern,
+		debounce: config.SignalDebounce,
+		procDir:  "/proc",
+		logger:   logger,
+	}, nil
+}
+
+// parseSignal resolves a signal name such as HUP, SIGHUP or a signal number
+func parseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		name = "HUP"
+	}
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// trigger schedules a signal if any of the changed files matches, restarting the debounce period
+func (s *processSignaler) trigger(changes syncChanges) {
+	if s.pattern != nil && !s.matches(changes) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.debounce, s.send)
+}
+
+// flush sends a signal still waiting for the debounce period right away
+func (s *processSignaler) flush() {
+	s.mu.Lock()
+	pending := s.timer != nil && s.timer.Stop()
+	s.mu.Unlock()
+	if pending {
+		s.send()
+	}
+}
+
+// matches reports whether any added, changed or removed file matches the signal pattern
+func (s *processSignaler) matches(changes syncChanges) bool {
+	for _, files := range [][]string{changes.Added, changes.Changed, changes.Removed} {
+		for _, filename := range files {
+			if s.pattern.MatchString(filename) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// send signals every target process
+func (s *processSignaler) send() {
+	pids, err := s.targets()
+	if err != nil {
+		s.logger.Printf("Error finding process to signal: %v", err)
+		return
+	}
+	if len(pids) == 0 {
+		s.logger.Printf("Warning: no process named %q to signal", s.process)
+		return
+	}
+
+	for _, pid := range pids {
+		process, err := os.FindProcess(pid)
+		if err == nil {
+			err = process.Signal(s.signal)
+		}
+		if err != nil {
+			s.logger.Printf("Error sending %v to pid %d: %v", s.signal, pid, err)
+			continue
+		}
+		atomic.AddInt64(&s.sent, 1)
+		s.logger.Printf("Sent %v to pid %d", s.signal, pid)
+	}
+}
+
+// targets returns the pids to signal, read from the pidfile or found by process name
+func (s *processSignaler) targets() ([]int, error) {
+	if s.pidFile != "" {
+		data, err := os.ReadFile(s.pidFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pidfile: %w", err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("invalid pid in %s", s.pidFile)
+		}
+		return []int{pid}, nil
+	}
+
+	return findProcesses(s.procDir, s.process)
+}
+
+// findProcesses returns the pids of all processes whose /proc/<pid>/comm matches name, except confsync itself.
+// In a shared PID namespace this includes processes of other containers in the pod.
+func findProcesses(procDir, name string) ([]int, error) {
+	if len(name) > commLength {
+		name = name[:commLength]
+	}
+
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		// Processes may exit while we look at them
+		comm, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSuffix(string(comm), "\n") == name {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// signalsSent returns the number of signals sent so far
+func (app *ConfsyncApp) signalsSent() int64 {
+	if app.signaler == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&app.signaler.sent)
+}
+
+// signalTarget describes the process signalled after changes, or "disabled"
+func (app *ConfsyncApp) signalTarget() string {
+	switch {
+	case app.signaler == nil:
+		return "disabled"
+	case app.signaler.pidFile != "":
+		return fmt.Sprintf("%v to pid in %s", app.signaler.signal, app.signaler.pidFile)
+	default:
+		return fmt.Sprintf("%v to %s", app.signaler.signal, app.signaler.process)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// signalNames maps the names accepted for -signal to signals. Only killing a process is
+// supported on this platform, so other signals fail when sent.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
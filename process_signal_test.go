@@ -0,0 +1,152 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessSignalDebounce(t *testing.T) {
+	received := make(chan os.Signal, 10)
+	signal.Notify(received, syscall.SIGUSR1)
+	defer signal.Stop(received)
+
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signaler, err := newProcessSignaler(Config{
+		SignalName:     "SIGUSR1",
+		SignalPidFile:  pidFile,
+		SignalPattern:  `\.conf$`,
+		SignalDebounce: 100 * time.Millisecond,
+	}, log.Default())
+	if err != nil {
+		t.Fatalf("Failed to create signaler: %v", err)
+	}
+
+	// Changes to files not matching the pattern don't signal
+	signaler.trigger(syncChanges{Changed: []string{"README.md"}})
+	// A burst of changes results in a single signal
+	for i := 0; i < 3; i++ {
+		signaler.trigger(syncChanges{Changed: []string{"nginx.conf"}})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a signal after the debounce period")
+	}
+	select {
+	case sig := <-received:
+		t.Errorf("Expected a single signal, got another %v", sig)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if sent := atomic.LoadInt64(&signaler.sent); sent != 1 {
+		t.Errorf("Expected 1 signal sent, got %d", sent)
+	}
+}
+
+func TestProcessSignalFlush(t *testing.T) {
+	received := make(chan os.Signal, 10)
+	signal.Notify(received, syscall.SIGUSR1)
+	defer signal.Stop(received)
+
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signaler, err := newProcessSignaler(Config{SignalName: "SIGUSR1", SignalPidFile: pidFile, SignalDebounce: time.Hour}, log.Default())
+	if err != nil {
+		t.Fatalf("Failed to create signaler: %v", err)
+	}
+
+	// Without a pending signal, flushing does nothing
+	signaler.flush()
+	signaler.trigger(syncChanges{Changed: []string{"nginx.conf"}})
+	signaler.flush()
+	signaler.flush()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the pending signal to be sent when flushed")
+	}
+	if sent := atomic.LoadInt64(&signaler.sent); sent != 1 {
+		t.Errorf("Expected 1 signal sent, got %d", sent)
+	}
+}
+
+func TestDrainSendsPendingSignal(t *testing.T) {
+	received := make(chan os.Signal, 10)
+	signal.Notify(received, syscall.SIGUSR1)
+	defer signal.Stop(received)
+
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:      "http://localhost/",
+		LocalDir:       t.TempDir(),
+		FilePattern:    ".*",
+		SignalName:     "SIGUSR1",
+		SignalPidFile:  pidFile,
+		SignalDebounce: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// A signal still waiting for its debounce is sent on shutdown instead of being dropped
+	app.signaler.trigger(syncChanges{Changed: []string{"nginx.conf"}})
+	app.drain()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected drain to send the pending signal")
+	}
+}
+
+func TestFindProcesses(t *testing.T) {
+	procDir := t.TempDir()
+	for pid, comm := range map[string]string{"12": "nginx\n", "34": "haproxy\n", "56": "nginx\n", "self": "nginx\n"} {
+		if err := os.MkdirAll(filepath.Join(procDir, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procDir, pid, "comm"), []byte(comm), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pids, err := findProcesses(procDir, "nginx")
+	if err != nil {
+		t.Fatalf("Failed to find processes: %v", err)
+	}
+	if len(pids) != 2 || pids[0] != 12 || pids[1] != 56 {
+		t.Errorf("Expected pids [12 56], got %v", pids)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{"HUP": syscall.SIGHUP, "sigusr2": syscall.SIGUSR2, "15": syscall.SIGTERM, "": syscall.SIGHUP} {
+		if sig, err := parseSignal(name); err != nil || sig != want {
+			t.Errorf("parseSignal(%q) = %v, %v; want %v", name, sig, err, want)
+		}
+	}
+	if _, err := parseSignal("BOGUS"); err == nil {
+		t.Error("Expected an error for an unknown signal")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partialDirName names the directory inside the local directory that downloads are written to before
+// they are moved into place. It outlives staging directories, so interrupted downloads can be resumed
+// by the next sync in every mode, and is never synced, removed as stale or reported as a change.
+const partialDirName = ".confsync-partial"
+
+// partialDownload is a temporary file left by an interrupted download that can be resumed with a Range
+// request, as long as the remote file still matches the validator
+type partialDownload struct {
+	validator string
+	size      int64
+}
+
+// resumeValidator returns the validator to send in If-Range when resuming a download of this response:
+// a strong ETag, or else Last-Modified. Weak ETags can't be used for range requests.
+func resumeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// partialFor returns the partial download kept in tempPath, if it can still be resumed
+func (app *ConfsyncApp) partialFor(tempPath string) (partialDownload, bool) {
+	app.partialMu.Lock()
+	partial, ok := app.partials[tempPath]
+	app.partialMu.Unlock()
+	if !ok {
+		return partialDownload{}, false
+	}
+
+	// The file may have been removed or changed since, e.g. along with a discarded transaction
+	if info, err := os.Stat(tempPath); err != nil || info.Size() != partial.size {
+		app.forgetPartial(tempPath)
+		return partialDownload{}, false
+	}
+	return partial, true
+}
+
+// keepPartial remembers an interrupted download in tempPath so that it can be resumed. Empty files are removed.
+func (app *ConfsyncApp) keepPartial(tempPath, validator string) {
+	info, err := os.Stat(tempPath)
+	if err != nil || info.Size() == 0 {
+		app.discardPartial(tempPath)
+		return
+	}
+
+	app.partialMu.Lock()
+	app.partials[tempPath] = partialDownload{validator: validator, size: info.Size()}
+	app.partialMu.Unlock()
+	if app.config.Verbose {
+		app.logger.Printf("Keeping %d bytes of %s to resume the download", info.Size(), tempPath)
+	}
+}
+
+// forgetPartial stops tracking a partial download, leaving the file alone
+func (app *ConfsyncApp) forgetPartial(tempPath string) {
+	app.partialMu.Lock()
+	delete(app.partials, tempPath)
+	app.partialMu.Unlock()
+}
+
+// discardPartial stops tracking a partial download and removes its file
+func (app *ConfsyncApp) discardPartial(tempPath string) {
+	app.forgetPartial(tempPath)
+	if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+		app.logger.Printf("Failed to remove temporary file %s: %v", tempPath, err)
+	}
+}
+
+// partialPath returns the temporary file a download is written to
+func (app *ConfsyncApp) partialPath(filename string) string {
+	return filepath.Join(app.config.LocalDir, partialDirName, filepath.FromSlash(filename)) + ".tmp"
+}
+
+// cleanPartials removes the temporary files that aren't kept for resuming and the directories left
+// empty, and stops tracking partial downloads whose file is gone. Downloads must not be running.
+func (app *ConfsyncApp) cleanPartials() {
+	app.partialMu.Lock()
+	defer app.partialMu.Unlock()
+	for tempPath := range app.partials {
+		if _, err := os.Stat(tempPath); os.IsNotExist(err) {
+			delete(app.partials, tempPath)
+		}
+	}
+
+	root := filepath.Join(app.config.LocalDir, partialDirName)
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if _, kept := app.partials[path]; !kept {
+			if err := os.Remove(path); err != nil {
+				app.logger.Printf("Failed to remove temporary file %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		app.logger.Printf("Warning: could not clean up %s: %v", root, err)
+	}
+	// Deepest first; os.Remove leaves directories that still hold partial downloads alone
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
+
+// parseContentRangeStart returns the first byte position of a Content-Range header like "bytes 100-199/200"
+func parseContentRangeStart(value string) (int64, error) {
+	rangeSpec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	start, _, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	position, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	return position, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content with ETag and range support, cutting off the first response after half of the content
+func rangeServer(content []byte, etag *string, ranges *[]string) *httptest.Server {
+	var mu sync.Mutex
+	requests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", *etag)
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "big.dat", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestResumeDownload(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	etag := `"v1"`
+	var ranges []string
+	server := rangeServer(content, &etag, &ranges)
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:  server.URL + "/",
+		LocalDir:   localDir,
+		MaxRetries: 1,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	entry := FileEntry{Name: "big.dat", Type: "file", Size: int64(len(content))}
+	if err := app.downloadFromMirrors(entry, localDir); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=5000-" {
+		t.Errorf("Expected a full request and a resumed one, got ranges %q", ranges)
+	}
+	data, err := os.ReadFile(filepath.Join(localDir, "big.dat"))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the resumed file to match the remote content, got %d bytes: %v", len(data), err)
+	}
+	if _, err := os.Stat(app.partialPath("big.dat")); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be gone, got %v", err)
+	}
+	if len(app.partials) != 0 {
+		t.Errorf("Expected no partial downloads left, got %v", app.partials)
+	}
+}
+
+func TestResumeDownloadRemoteChanged(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 1000))
+	etag := `"v1"`
+	var ranges []string
+	server := rangeServer(content, &etag, &ranges)
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{RemoteURL: server.URL + "/", LocalDir: localDir})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	entry := FileEntry{Name: "big.dat", Type: "file", Size: int64(len(content))}
+	if err := app.downloadFile(entry, localDir, server.URL); err == nil {
+		t.Fatalf("Expected the interrupted download to fail")
+	}
+	if partial, ok := app.partialFor(app.partialPath("big.dat")); !ok || partial.size != 5000 || partial.validator != `"v1"` {
+		t.Fatalf("Expected the partial download to be kept, got %+v", partial)
+	}
+
+	// If-Range doesn't match the new ETag, so the server sends the whole file
+	etag = `"v2"`
+	if err := app.downloadFile(entry, localDir, server.URL); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if ranges[1] != "bytes=5000-" {
+		t.Errorf("Expected a range request, got %q", ranges[1])
+	}
+	data, err := os.ReadFile(filepath.Join(localDir, "big.dat"))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the file to be downloaded in full, got %d bytes: %v", len(data), err)
+	}
+}
+
+func TestDownloadSizeMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("short\n"))
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{RemoteURL: server.URL + "/", LocalDir: localDir})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	err = app.downloadFile(FileEntry{Name: "a.yaml", Type: "file", Size: 100}, localDir, server.URL)
+	if err == nil || !strings.Contains(err.Error(), "size mismatch") {
+		t.Fatalf("Expected a size mismatch, got %v", err)
+	}
+	for _, path := range []string{filepath.Join(localDir, "a.yaml"), app.partialPath("a.yaml")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist, got %v", path, err)
+		}
+	}
+
+	// Unknown sizes aren't checked
+	if err := app.downloadFile(FileEntry{Name: "a.yaml", Type: "file", Size: -1}, localDir, server.URL); err != nil {
+		t.Errorf("Expected the download to succeed, got %v", err)
+	}
+}
+
+func TestResumeDownloadAcrossSyncs(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	etag := `"v1"`
+	var ranges []string
+	files := rangeServer(content, &etag, &ranges)
+	defer files.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"name": "big.bin", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": %d}]`, len(content))
+			return
+		}
+		http.Redirect(w, r, files.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:     server.URL + "/",
+		LocalDir:      localDir,
+		FilePattern:   ".*",
+		DeleteFiles:   true,
+		Transactional: true,
+		RetryDelay:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// The interrupted download is kept out of the synced files, so -delete leaves it alone
+	if err := app.syncFiles(); err == nil {
+		t.Fatalf("Expected the transactional sync to fail with the interrupted download")
+	}
+	if _, ok := app.partialFor(app.partialPath("big.bin")); !ok {
+		t.Fatalf("Expected the partial download to be kept")
+	}
+	if files, err := app.scanLocalFiles(); err != nil || len(files) != 0 {
+		t.Errorf("Expected no synced files, got %v, %v", files, err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=5000-" {
+		t.Errorf("Expected the next sync to resume the download, got ranges %q", ranges)
+	}
+	if data, err := os.ReadFile(filepath.Join(localDir, "big.bin")); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the resumed file to match the remote content, got %d bytes: %v", len(data), err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, partialDirName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the directory of partial downloads to be removed once empty, got %v", err)
+	}
+}
+
+func TestCleanPartials(t *testing.T) {
+	app, err := NewConfsyncApp(Config{RemoteURL: "http://localhost/", LocalDir: t.TempDir(), FilePattern: ".*"})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	kept, leftover := app.partialPath("a.yaml"), app.partialPath("ns/b.yaml")
+	for _, path := range []string{kept, leftover} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.keepPartial(kept, `"v1"`)
+
+	app.cleanPartials()
+	if _, ok := app.partialFor(kept); !ok {
+		t.Fatalf("Expected the partial download to be kept")
+	}
+	if _, err := os.Stat(filepath.Dir(leftover)); !os.IsNotExist(err) {
+		t.Errorf("Expected the leftover temporary file and its directory to be removed, got %v", err)
+	}
+
+	if err := os.Remove(kept); err != nil {
+		t.Fatal(err)
+	}
+	app.cleanPartials()
+	if len(app.partials) != 0 {
+		t.Errorf("Expected removed partial downloads to be forgotten, got %v", app.partials)
+	}
+	if _, err := os.Stat(filepath.Join(app.config.LocalDir, partialDirName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty directory to be removed, got %v", err)
+	}
+}
+
+func TestParseContentRangeStart(t *testing.T) {
+	tests := []struct {
+		value string
+		start int64
+		valid bool
+	}{
+		{"bytes 100-199/200", 100, true},
+		{"bytes 0-9/*", 0, true},
+		{"bytes */200", 0, false},
+		{"items 1-2/3", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		start, err := parseContentRangeStart(tt.value)
+		if (err == nil) != tt.valid || start != tt.start {
+			t.Errorf("parseContentRangeStart(%q) = %d, %v", tt.value, start, err)
+		}
+	}
+}
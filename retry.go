@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryAfter caps how long a Retry-After header can delay the next attempt
+const maxRetryAfter = 5 * time.Minute
+
+// httpStatusError is returned for unexpected response statuses
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+// newHTTPStatusError creates the error for an unexpected response, including the delay the server asked for
+func newHTTPStatusError(resp *http.Response) *httpStatusError {
+	err := &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", e.StatusCode, e.Status)
+}
+
+// retryable reports whether a request that failed with this status is worth repeating. Client errors are
+// permanent, except for timeouts and rate limiting.
+func (e *httpStatusError) retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode < 400 || e.StatusCode >= 500
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date, capped at maxRetryAfter
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// isRetryable reports whether a failed request should be repeated
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.retryable()
+	}
+	return true
+}
+
+// backoffDelay returns how long to wait before the given retry: the exponential backoff from RetryDelay
+// with up to 50% random jitter, or the delay the server asked for in Retry-After if that is longer
+func (app *ConfsyncApp) backoffDelay(retry int, lastErr error) time.Duration {
+	backoff := time.Duration(int64(app.config.RetryDelay) * int64(1<<(retry-1)))
+	delay := backoff + randomDuration(backoff/2)
+
+	var statusErr *httpStatusError
+	if errors.As(lastErr, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+	return delay
+}
+
+// downloadWithRetries downloads a file from the remote base URL, retrying failed downloads with backoff
+// up to maxRetries times. Cancelled downloads and permanent errors are not retried.
+func (app *ConfsyncApp) downloadWithRetries(entry FileEntry, root, base string, maxRetries int) error {
+	ctx := app.downloadCtx
+	var err error
+	for retry := 0; retry <= maxRetries; retry++ {
+		if retry > 0 {
+			delay := app.backoffDelay(retry, err)
+			if app.config.Verbose {
+				app.logger.Printf("Retrying download of %s (attempt %d/%d) after %v: %v", entry.Name, retry, maxRetries, delay, err)
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("download of %s was cancelled", entry.Name)
+			case <-time.After(delay):
+			}
+		}
+
+		err = app.downloadFile(entry, root, base)
+		if err == nil || errors.Is(err, errNotModified) || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloadRetries(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	var retryAfterGap time.Duration
+	var lastRateLimited time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "flaky.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "limited.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "forbidden.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+		case "/flaky.yaml":
+			// Transient errors are retried
+			if requests[r.URL.Path] < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, "x\n")
+		case "/limited.yaml":
+			// Retry-After is honored even if it is longer than the backoff
+			if requests[r.URL.Path] == 1 {
+				lastRateLimited = time.Now()
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			retryAfterGap = time.Since(lastRateLimited)
+			fmt.Fprint(w, "x\n")
+		case "/forbidden.yaml":
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+		MaxRetries:  3,
+		RetryDelay:  10 * time.Millisecond,
+		Concurrency: 3,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"flaky.yaml", "limited.yaml"} {
+		if _, err := os.Stat(filepath.Join(localDir, name)); err != nil {
+			t.Errorf("Expected %s to be downloaded after retrying: %v", name, err)
+		}
+	}
+	if requests["/flaky.yaml"] != 3 {
+		t.Errorf("Expected 3 requests for flaky.yaml, got %d", requests["/flaky.yaml"])
+	}
+	if retryAfterGap < 900*time.Millisecond {
+		t.Errorf("Expected the retry to wait for Retry-After, waited %v", retryAfterGap)
+	}
+	if requests["/forbidden.yaml"] != 1 {
+		t.Errorf("Expected no retries after a 403, got %d requests", requests["/forbidden.yaml"])
+	}
+}
+
+func TestListingStopsOnClientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    t.TempDir(),
+		FilePattern: `\.yaml$`,
+		MaxRetries:  3,
+		RetryDelay:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err == nil {
+		t.Fatal("Expected the sync to fail")
+	}
+	if requests != 1 {
+		t.Errorf("Expected a 404 listing not to be retried, got %d requests", requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 7, 27, 10, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"30":                            30 * time.Second,
+		"-5":                            0,
+		"86400":                         maxRetryAfter,
+		"Sun, 27 Jul 2025 10:01:00 GMT": time.Minute,
+		"Sun, 27 Jul 2025 09:00:00 GMT": 0,
+		"soon":                          0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
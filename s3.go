@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Supported remote source types
+const (
+	sourceHTTP = "http"
+	sourceS3   = "s3"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body, sent with every signed GET
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Credentials holds the keys used to sign S3 requests
+type s3Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// s3Client lists and fetches objects from an S3-compatible bucket using path-style addressing
+type s3Client struct {
+	endpoint      *url.URL
+	bucket        string
+	prefix        string
+	region        string
+	accessKeyFile string
+	secretKeyFile string
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response used for syncing
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// newS3Client parses a path-style bucket URL such as https://minio.local:9000/bucket/prefix/
+func newS3Client(config Config) (*s3Client, error) {
+	endpoint, err := url.Parse(config.RemoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 URL %q: expected http(s)://host/bucket[/prefix]", config.RemoteURL)
+	}
+
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(endpoint.Path, "/"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q: missing bucket name", config.RemoteURL)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	region := config.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Client{
+		endpoint:      &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host},
+		bucket:        bucket,
+		prefix:        prefix,
+		region:        region,
+		accessKeyFile: config.S3AccessKeyFile,
+		secretKeyFile: config.S3SecretKeyFile,
+	}, nil
+}
+
+// credentials loads the signing keys from the configured files, falling back to the
+// standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+// Files are re-read on every call so rotated secrets are picked up without a restart.
+func (c *s3Client) credentials() (s3Credentials, error) {
+	creds := s3Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if c.accessKeyFile != "" {
+		data, err := os.ReadFile(c.accessKeyFile)
+		if err != nil {
+			return creds, fmt.Errorf("failed to read S3 access key file: %w", err)
+		}
+		creds.AccessKey = strings.TrimSpace(string(data))
+	}
+
+	if c.secretKeyFile != "" {
+		data, err := os.ReadFile(c.secretKeyFile)
+		if err != nil {
+			return creds, fmt.Errorf("failed to read S3 secret key file: %w", err)
+		}
+		creds.SecretKey = strings.TrimSpace(string(data))
+	}
+
+	return creds, nil
+}
+
+// newRequest builds a request for an object key (or the bucket itself when key is empty). It is
+// signed by sign once all its headers are set.
+func (c *s3Client) newRequest(ctx context.Context, method, key string, query url.Values) (*http.Request, error) {
+	reqURL := c.requestURL(key, query)
+	return http.NewRequestWithContext(ctx, method, reqURL.String(), nil)
+}
+
+// sign signs a request right before it is sent
+func (c *s3Client) sign(req *http.Request) error {
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+
+	// Without credentials the request is sent unsigned, which works for public buckets
+	if creds.AccessKey != "" && creds.SecretKey != "" {
+		signS3Request(req, creds, c.region, time.Now())
+	}
+	return nil
+}
+
+// signRequest signs a request to the S3 source once all its headers are set. Requests to other
+// sources are left as they are.
+func (app *ConfsyncApp) signRequest(req *http.Request) error {
+	if app.s3 == nil {
+		return nil
+	}
+	if err := app.s3.sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	return nil
+}
+
+// requestURL returns the path-style URL of an object key (or the bucket itself when key is empty),
+// encoded exactly as it is signed
+func (c *s3Client) requestURL(key string, query url.Values) *url.URL {
+	objectPath := "/" + c.bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+
+	reqURL := *c.endpoint
+	reqURL.Path = objectPath
+	reqURL.RawPath = awsURIEncode(objectPath, false)
+	reqURL.RawQuery = canonicalQueryString(query)
+	return &reqURL
+}
+
+// objectRequest builds a GET request for a file relative to the configured prefix
+func (c *s3Client) objectRequest(ctx context.Context, relPath string) (*http.Request, error) {
+	return c.newRequest(ctx, "GET", c.prefix+relPath, nil)
+}
+
+// listS3Objects lists all objects under the configured prefix with ListObjectsV2,
+// following continuation tokens. Each page is fetched with the usual retry logic.
+func (app *ConfsyncApp) listS3Objects() ([]FileEntry, error) {
+	var files []FileEntry
+	continuationToken := ""
+
+	for {
+		atomic.AddInt64(&app.totalReqs, 1)
+
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if app.s3.prefix != "" {
+			query.Set("prefix", app.s3.prefix)
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		var page listBucketResult
+		err := app.fetchWithRetries(func() (*http.Request, error) {
+			req, err := app.s3.newRequest(context.Background(), "GET", "", query)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("User-Agent", app.config.UserAgent)
+			return req, nil
+		}, func(resp *http.Response, body []byte) error {
+			page = listBucketResult{}
+			if err := xml.Unmarshal(body, &page); err != nil {
+				return fmt.Errorf("failed to parse ListObjectsV2 response: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			app.setLastError(fmt.Sprintf("failed after %d retries: %v", app.config.MaxRetries, err))
+			return nil, fmt.Errorf("failed after %d retries: %w", app.config.MaxRetries, err)
+		}
+
+		for _, object := range page.Contents {
+			relPath := strings.TrimPrefix(object.Key, app.s3.prefix)
+			// Skip "folder" placeholder objects
+			if relPath == "" || strings.HasSuffix(relPath, "/") {
+				continue
+			}
+			if !isSafeRelPath(relPath) {
+				app.logger.Printf("Warning: ignoring object with unsafe key %q", object.Key)
+				continue
+			}
+			if app.config.MaxDepth >= 0 && strings.Count(relPath, "/") > app.config.MaxDepth {
+				continue
+			}
+
+			files = append(files, FileEntry{
+				Name:  relPath,
+				Type:  "file",
+				MTime: object.LastModified,
+				Size:  object.Size,
+				ETag:  object.ETag,
+			})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return files, nil
+		}
+		continuationToken = page.NextContinuationToken
+	}
+}
+
+// isSafeRelPath reports whether every segment of a slash-separated path is a safe entry name
+func isSafeRelPath(relPath string) bool {
+	for _, segment := range strings.Split(relPath, "/") {
+		if !isSafeEntryName(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// signS3Request adds AWS Signature Version 4 headers to a request with an empty body.
+// The host, range and x-amz-* headers are signed.
+func signS3Request(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 computes an HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQueryString encodes query parameters sorted by name, as required by SigV4
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything except unreserved characters, optionally keeping slashes
+func awsURIEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2025, 7, 30, 10, 17, 42, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/5 * * * *", time.Date(2025, 7, 30, 10, 20, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 7, 30, 11, 0, 0, 0, time.UTC)},
+		{"30 9-17 * * MON-FRI", time.Date(2025, 7, 30, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * sat,sun", time.Date(2025, 8, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"15/20 10 * * *", time.Date(2025, 7, 30, 10, 35, 0, 0, time.UTC)},
+		// Day of month and day of week match either way when both are restricted
+		{"0 0 15 * 5", time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 8, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if got := schedule.next(now); !got.Equal(test.want) {
+			t.Errorf("next(%q) = %v, want %v", test.expr, got, test.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * * MON-FUN", "*/0 * * * *", "0 0 30 2 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected parseCron(%q) to fail", expr)
+		}
+	}
+}
+
+func TestNextSyncDelay(t *testing.T) {
+	app := &ConfsyncApp{config: Config{PollInterval: time.Minute, Jitter: 10 * time.Second}}
+	for i := 0; i < 100; i++ {
+		if delay := app.nextSyncDelay(time.Now()); delay < time.Minute || delay >= time.Minute+10*time.Second {
+			t.Fatalf("Expected a delay between 1m and 1m10s, got %v", delay)
+		}
+	}
+
+	app.schedule, _ = parseCron("0 * * * *")
+	app.config.Jitter = 0
+	now := time.Date(2025, 7, 30, 10, 45, 0, 0, time.UTC)
+	if delay := app.nextSyncDelay(now); delay != 15*time.Minute {
+		t.Errorf("Expected 15m until the next cron match, got %v", delay)
+	}
+	if interval := app.syncInterval(); interval != time.Hour {
+		t.Errorf("Expected an hourly sync interval, got %v", interval)
+	}
+}
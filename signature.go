@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Supported detached signature formats
+const (
+	signatureFormatMinisign = "minisign"
+	signatureFormatEd25519  = "ed25519"
+)
+
+// errSignatureInvalid is wrapped by every signature verification failure
+var errSignatureInvalid = errors.New("signature verification failed")
+
+// signatureVerifier checks detached signatures against a trusted ed25519 public key
+type signatureVerifier struct {
+	format    string
+	publicKey ed25519.PublicKey
+	// keyID is the minisign key identifier; empty for raw ed25519 keys
+	keyID []byte
+}
+
+// newSignatureVerifier loads the public key from keyFile in the given signature format
+func newSignatureVerifier(format, keyFile string) (*signatureVerifier, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature public key: %w", err)
+	}
+
+	switch format {
+	case signatureFormatMinisign:
+		keyID, publicKey, err := parseMinisignPublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return &signatureVerifier{format: format, publicKey: publicKey, keyID: keyID}, nil
+	case signatureFormatEd25519:
+		publicKey, err := parseEd25519PublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return &signatureVerifier{format: format, publicKey: publicKey}, nil
+	default:
+		return nil, fmt.Errorf("invalid signature format %q: must be one of minisign, ed25519", format)
+	}
+}
+
+// suffix returns the extension appended to a document URL to locate its detached signature
+func (v *signatureVerifier) suffix() string {
+	if v.format == signatureFormatMinisign {
+		return ".minisig"
+	}
+	return ".sig"
+}
+
+// listingSignatureURL returns the URL of the detached signature of a directory listing, which lives
+// next to the directory: configs/ -> configs.minisig. The listing of the server root is signed by
+// index.minisig in the root.
+func (v *signatureVerifier) listingSignatureURL(listingURL string) (string, error) {
+	u, err := url.Parse(listingURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid listing URL: %w", err)
+	}
+	dir := strings.TrimSuffix(u.Path, "/")
+	if dir == "" {
+		dir = "/index"
+	}
+	u.Path = dir + v.suffix()
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// verify checks a detached signature over message
+func (v *signatureVerifier) verify(message, signature []byte) error {
+	if v.format == signatureFormatMinisign {
+		return v.verifyMinisign(message, signature)
+	}
+
+	raw, err := decodeKeyMaterial(signature, ed25519.SignatureSize)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature: %v", errSignatureInvalid, err)
+	}
+	if !ed25519.Verify(v.publicKey, message, raw) {
+		return fmt.Errorf("%w: signature does not match", errSignatureInvalid)
+	}
+	return nil
+}
+
+// verifyMinisign checks a minisign signature file, including its trusted comment.
+// Both legacy ("Ed") and prehashed ("ED", BLAKE2b-512) signatures are accepted.
+func (v *signatureVerifier) verifyMinisign(message, signature []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%w: malformed minisign signature", errSignatureInvalid)
+	}
+
+	sigBlob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigBlob) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed minisign signature", errSignatureInvalid)
+	}
+	algorithm, keyID, sig := string(sigBlob[:2]), sigBlob[2:10], sigBlob[10:]
+
+	if !bytes.Equal(keyID, v.keyID) {
+		return fmt.Errorf("%w: signed with key %X, expected %X", errSignatureInvalid, reverseBytes(keyID), reverseBytes(v.keyID))
+	}
+
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		digest := blake2b512(message)
+		message = digest[:]
+	default:
+		return fmt.Errorf("%w: unsupported minisign algorithm %q", errSignatureInvalid, algorithm)
+	}
+
+	if !ed25519.Verify(v.publicKey, message, sig) {
+		return fmt.Errorf("%w: signature does not match", errSignatureInvalid)
+	}
+
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed minisign global signature", errSignatureInvalid)
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(v.publicKey, append(append([]byte(nil), sig...), trustedComment...), globalSig) {
+		return fmt.Errorf("%w: trusted comment signature does not match", errSignatureInvalid)
+	}
+
+	return nil
+}
+
+// parseMinisignPublicKey parses a minisign public key file or bare base64 key line
+func parseMinisignPublicKey(data []byte) ([]byte, ed25519.PublicKey, error) {
+	var encoded string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			encoded = line
+			break
+		}
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(blob) != 2+8+ed25519.PublicKeySize || string(blob[:2]) != "Ed" {
+		return nil, nil, fmt.Errorf("invalid minisign public key")
+	}
+	return blob[2:10], ed25519.PublicKey(blob[10:]), nil
+}
+
+// parseEd25519PublicKey parses a raw ed25519 public key given as PEM (PKIX), base64 or hex
+func parseEd25519PublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ed25519 public key: %w", err)
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid ed25519 public key: PEM block holds a %T", key)
+		}
+		return publicKey, nil
+	}
+
+	raw, err := decodeKeyMaterial(data, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ed25519 public key: %w", err)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// decodeKeyMaterial accepts raw bytes or their base64 or hex encoding, of exactly size bytes
+func decodeKeyMaterial(data []byte, size int) ([]byte, error) {
+	if len(data) == size {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil && len(raw) == size {
+		return raw, nil
+	}
+	if raw, err := hex.DecodeString(text); err == nil && len(raw) == size {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes as raw, base64 or hex", size)
+}
+
+// reverseBytes returns a reversed copy of b; minisign displays key IDs as little-endian numbers
+func reverseBytes(b []byte) []byte {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return reversed
+}
+
+// verifyDetachedSignature fetches the signature built by newRequest and checks it against body
+func (app *ConfsyncApp) verifyDetachedSignature(body []byte, newRequest func() (*http.Request, error)) error {
+	atomic.AddInt64(&app.totalReqs, 1)
+
+	req, err := newRequest()
+	if err == nil {
+		err = app.signRequest(req)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create signature request: %w", err)
+	}
+
+	resp, err := app.listingClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature: %w", err)
+	}
+
+	signature, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		app.logger.Printf("Failed to close response body: %v", closeErr)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: signature %s not available: server returned status %d", errSignatureInvalid, req.URL.Redacted(), resp.StatusCode)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+
+	return app.verifier.verify(body, signature)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlake2b512(t *testing.T) {
+	testCases := map[string]string{
+		"":                        "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+		"abc":                     "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		string(make([]byte, 128)): "865939e120e6805438478841afb739ae4250cf372653078a065cdcfffca4caf798e6d462b65d658fc165782640eded70963449ae1500fb0f24981d7727e22c41",
+		string(make([]byte, 129)): "a60edba343e7a6933c14d203d2e535f35e6deb6c8a4f8e624c1a6f6e2612860447cb4c37e5aa11bcf03b7c3eea7228eb8b998f922794f2d1b8f2dc63f03bd3fa",
+	}
+
+	for input, expected := range testCases {
+		digest := blake2b512([]byte(input))
+		if got := hex.EncodeToString(digest[:]); got != expected {
+			t.Errorf("blake2b512 of %d bytes: expected %s, got %s", len(input), expected, got)
+		}
+	}
+}
+
+// minisignKey generates a key pair and returns the public key file contents and a signing function
+func minisignKey(t *testing.T) (string, func(message []byte, prehashed bool) string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	publicFile := "untrusted comment: minisign public key 0807060504030201\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...)) + "\n"
+
+	sign := func(message []byte, prehashed bool) string {
+		algorithm := "Ed"
+		if prehashed {
+			algorithm = "ED"
+			digest := blake2b512(message)
+			message = digest[:]
+		}
+		sig := ed25519.Sign(privateKey, message)
+		trustedComment := "timestamp:1753590200\tfile:manifest.json"
+		globalSig := ed25519.Sign(privateKey, append(append([]byte(nil), sig...), trustedComment...))
+
+		return "untrusted comment: signature from minisign secret key\n" +
+			base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)) + "\n" +
+			"trusted comment: " + trustedComment + "\n" +
+			base64.StdEncoding.EncodeToString(globalSig) + "\n"
+	}
+
+	return publicFile, sign
+}
+
+func TestMinisignVerification(t *testing.T) {
+	publicFile, sign := minisignKey(t)
+	keyFile := filepath.Join(t.TempDir(), "minisign.pub")
+	if err := os.WriteFile(keyFile, []byte(publicFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := newSignatureVerifier(signatureFormatMinisign, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+
+	message := []byte(`[{"name": "a.yaml"}]`)
+	for _, prehashed := range []bool{false, true} {
+		if err := verifier.verify(message, []byte(sign(message, prehashed))); err != nil {
+			t.Errorf("Expected valid signature (prehashed=%v) to verify: %v", prehashed, err)
+		}
+	}
+
+	if err := verifier.verify([]byte("tampered"), []byte(sign(message, true))); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("Expected tampered message to fail verification, got %v", err)
+	}
+
+	tamperedComment := strings.Replace(sign(message, true), "timestamp:1753590200", "timestamp:1753590201", 1)
+	if err := verifier.verify(message, []byte(tamperedComment)); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("Expected tampered trusted comment to fail verification, got %v", err)
+	}
+
+	_, otherSign := minisignKey(t)
+	if err := verifier.verify(message, []byte(otherSign(message, true))); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("Expected signature from another key to fail verification, got %v", err)
+	}
+}
+
+func TestEd25519Verification(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ed25519.pub")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(publicKey)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := newSignatureVerifier(signatureFormatEd25519, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+
+	message := []byte("listing")
+	signature := ed25519.Sign(privateKey, message)
+
+	if err := verifier.verify(message, signature); err != nil {
+		t.Errorf("Expected raw signature to verify: %v", err)
+	}
+	if err := verifier.verify(message, []byte(base64.StdEncoding.EncodeToString(signature))); err != nil {
+		t.Errorf("Expected base64 signature to verify: %v", err)
+	}
+	if err := verifier.verify([]byte("other"), signature); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("Expected mismatching signature to fail verification, got %v", err)
+	}
+}
+
+func TestListingSignatureURL(t *testing.T) {
+	verifier := &signatureVerifier{format: signatureFormatMinisign}
+	for listingURL, expected := range map[string]string{
+		"https://example.com/configs/":           "https://example.com/configs.minisig",
+		"https://example.com/configs/namespace/": "https://example.com/configs/namespace.minisig",
+		"http://example.com:8080/":               "http://example.com:8080/index.minisig",
+		"http://example.com:8080":                "http://example.com:8080/index.minisig",
+	} {
+		got, err := verifier.listingSignatureURL(listingURL)
+		if err != nil || got != expected {
+			t.Errorf("Signature URL of %s: expected %s, got %s, %v", listingURL, expected, got, err)
+		}
+	}
+}
+
+func TestSignedRootListingSync(t *testing.T) {
+	publicFile, sign := minisignKey(t)
+	keyFile := filepath.Join(t.TempDir(), "minisign.pub")
+	if err := os.WriteFile(keyFile, []byte(publicFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	listing := `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`
+	signature := sign([]byte(listing), false)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, listing)
+		case "/index.minisig":
+			fmt.Fprint(w, signature)
+		default:
+			fmt.Fprint(w, "a\n")
+		}
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:       server.URL + "/",
+		LocalDir:        localDir,
+		FilePattern:     ".*",
+		SignatureKey:    keyFile,
+		SignatureFormat: signatureFormatMinisign,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync of a signed root listing failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "a.yaml")); err != nil {
+		t.Errorf("Expected a.yaml to be synced: %v", err)
+	}
+}
+
+func TestSignedListingSync(t *testing.T) {
+	publicFile, sign := minisignKey(t)
+	keyFile := filepath.Join(t.TempDir(), "minisign.pub")
+	if err := os.WriteFile(keyFile, []byte(publicFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	listing := `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`
+	signature := sign([]byte(listing), true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/configs/":
+			fmt.Fprint(w, listing)
+		case "/configs.minisig":
+			fmt.Fprint(w, signature)
+		default:
+			fmt.Fprint(w, "a\n")
+		}
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:       server.URL + "/configs/",
+		LocalDir:        localDir,
+		FilePattern:     ".*",
+		SignatureKey:    keyFile,
+		SignatureFormat: signatureFormatMinisign,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync with valid signature failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "a.yaml")); err != nil {
+		t.Errorf("Expected a.yaml to be synced: %v", err)
+	}
+
+	listing = `[{"name": "evil.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`
+	if err := app.syncFiles(); !errors.Is(err, errSignatureInvalid) {
+		t.Fatalf("Expected sync with invalid signature to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "evil.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected no files to be synced from an unverified listing")
+	}
+	if health := app.getHealthStatus(); !strings.Contains(health.LastError, "signature") {
+		t.Errorf("Expected signature failure in last error, got %q", health.LastError)
+	}
+}
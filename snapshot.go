@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// In snapshot mode the local directory is laid out like a Kubernetes ConfigMap volume:
+//
+//	..2025_07_27_04_23_20.000000000/  one complete generation of the synced files
+//	..data -> ..2025_07_27_04_23_20.000000000
+//	app.yaml -> ..data/app.yaml
+//	conf.d -> ..data/conf.d
+//
+// A sync writes a whole new generation and then renames a fresh ..data symlink over the
+// old one, so readers resolving paths through ..data see either the old or the new set.
+const (
+	snapshotDataLink   = "..data"
+	snapshotDataTmp    = "..data_tmp"
+	snapshotLinkTmp    = "..link_tmp"
+	snapshotDirLayout  = "..2006_01_02_15_04_05.000000000"
+	snapshotNamePrefix = ".."
+)
+
+// currentGeneration returns the directory the ..data symlink points to, or "" before the first snapshot
+func (app *ConfsyncApp) currentGeneration() string {
+	target, err := os.Readlink(filepath.Join(app.config.LocalDir, snapshotDataLink))
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(app.config.LocalDir, target)
+}
+
+// newGeneration creates an empty, timestamped generation directory
+func (app *ConfsyncApp) newGeneration() (string, error) {
+	if err := os.MkdirAll(app.config.LocalDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", app.config.LocalDir, err)
+	}
+
+	generation := filepath.Join(app.config.LocalDir, time.Now().UTC().Format(snapshotDirLayout))
+	if err := os.Mkdir(generation, 0755); err != nil {
+		return "", fmt.Errorf("failed to create generation %s: %w", generation, err)
+	}
+	return generation, nil
+}
+
+// listGeneration returns the slash-separated paths of all regular files in a generation
+func listGeneration(generation string) ([]string, error) {
+	var files []string
+	if generation == "" {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(generation, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			relPath, err := filepath.Rel(generation, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// scanMatchingFiles returns the files of a plain local directory that match the pattern,
+// which the first generation takes over
+func (app *ConfsyncApp) scanMatchingFiles() ([]string, error) {
+	localFiles, err := app.scanLocalFiles()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var files []string
+	for _, filename := range localFiles {
+		if app.fileRegex.MatchString(filename) {
+			files = append(files, filename)
+		}
+	}
+	return files, nil
+}
+
+// carryOverFiles links every file of the previous generation that the new one doesn't have yet,
+// except those being removed. This covers unchanged files as well as failed or 304 downloads.
+func carryOverFiles(previous, generation string, previousFiles []string, removed map[string]bool) error {
+	for _, filename := range previousFiles {
+		if removed[filename] {
+			continue
+		}
+
+		target := filepath.Join(generation, filepath.FromSlash(filename))
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+		}
+		if err := linkOrCopy(filepath.Join(previous, filepath.FromSlash(filename)), target); err != nil {
+			return fmt.Errorf("failed to carry over %s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// linkOrCopy hard links src to dst, falling back to a copy that keeps the modification time
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to a new file dst, keeping its permissions and modification time
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			log.Printf("Failed to close %s: %v", src, closeErr)
+		}
+	}()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// activateGeneration atomically points ..data at a generation, then updates the top-level
+// symlinks and removes older generations
+func (app *ConfsyncApp) activateGeneration(generation string) error {
+	localDir := app.config.LocalDir
+
+	tmpLink := filepath.Join(localDir, snapshotDataTmp)
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale %s: %w", tmpLink, err)
+	}
+	if err := os.Symlink(filepath.Base(generation), tmpLink); err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpLink, err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(localDir, snapshotDataLink)); err != nil {
+		return fmt.Errorf("failed to swap %s: %w", snapshotDataLink, err)
+	}
+
+	entries, err := os.ReadDir(generation)
+	if err != nil {
+		return fmt.Errorf("failed to read generation %s: %w", generation, err)
+	}
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.Name()] = true
+		if err := app.linkTopLevel(entry.Name()); err != nil {
+			app.logger.Printf("Error linking %s: %v", entry.Name(), err)
+		}
+	}
+
+	// Drop the top-level symlinks of names that are gone from the new generation
+	entries, err = os.ReadDir(localDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", localDir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if present[name] || entry.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if target, err := os.Readlink(filepath.Join(localDir, name)); err == nil && target == filepath.Join(snapshotDataLink, name) {
+			if err := os.Remove(filepath.Join(localDir, name)); err != nil {
+				app.logger.Printf("Error removing %s: %v", name, err)
+			}
+		}
+	}
+
+	app.removeOldGenerations(generation)
+	return nil
+}
+
+// linkTopLevel points a top-level name of the local directory into ..data, replacing a plain file of the same name
+func (app *ConfsyncApp) linkTopLevel(name string) error {
+	path := filepath.Join(app.config.LocalDir, name)
+	target := filepath.Join(snapshotDataLink, name)
+
+	if existing, err := os.Readlink(path); err == nil && existing == target {
+		return nil
+	}
+
+	tmpLink := filepath.Join(app.config.LocalDir, snapshotLinkTmp)
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, path); err != nil {
+		if removeErr := os.Remove(tmpLink); removeErr != nil {
+			app.logger.Printf("Failed to remove %s: %v", tmpLink, removeErr)
+		}
+		return fmt.Errorf("cannot replace %s with a symlink: %w", path, err)
+	}
+	return nil
+}
+
+// removeOldGenerations deletes every generation directory except the active one
+func (app *ConfsyncApp) removeOldGenerations(active string) {
+	entries, err := os.ReadDir(app.config.LocalDir)
+	if err != nil {
+		app.logger.Printf("Warning: could not list old generations: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), snapshotNamePrefix) || entry.Name() == filepath.Base(active) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(app.config.LocalDir, entry.Name())); err != nil {
+			app.logger.Printf("Error removing old generation %s: %v", entry.Name(), err)
+		} else if app.config.Verbose {
+			app.logger.Printf("Removed old generation %s", entry.Name())
+		}
+	}
+}
+
+// commitGeneration completes a new generation with the files carried over from the previous one
+// and activates it. A generation without any change is discarded.
+func (app *ConfsyncApp) commitGeneration(generation, previous string, previousFiles, removed []string, changed bool) error {
+	if !changed {
+		if err := os.RemoveAll(generation); err != nil {
+			app.logger.Printf("Error removing unused generation %s: %v", generation, err)
+		}
+		return nil
+	}
+
+	removedSet := make(map[string]bool, len(removed))
+	for _, filename := range removed {
+		removedSet[filename] = true
+	}
+
+	if err := carryOverFiles(previous, generation, previousFiles, removedSet); err != nil {
+		if removeErr := os.RemoveAll(generation); removeErr != nil {
+			app.logger.Printf("Error removing incomplete generation %s: %v", generation, removeErr)
+		}
+		return err
+	}
+
+	if err := app.activateGeneration(generation); err != nil {
+		return err
+	}
+
+	if app.config.Verbose {
+		app.logger.Printf("Activated generation %s", filepath.Base(generation))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// generations returns the generation directories in a snapshot mode local directory
+func generations(t *testing.T, localDir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), snapshotNamePrefix) {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs
+}
+
+func TestSnapshotSync(t *testing.T) {
+	var mu sync.Mutex
+	listing := map[string]string{
+		"/":        `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}, {"name": "conf.d", "type": "directory", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT"}]`,
+		"/conf.d/": `[{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`,
+	}
+	files := map[string]string{"/a.yaml": "a\n", "/conf.d/b.yaml": "b\n"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if body, ok := listing[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, body)
+			return
+		}
+		if body, ok := files[r.URL.Path]; ok {
+			fmt.Fprint(w, body)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	// A plain directory from before snapshot mode was enabled
+	localDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(localDir, "a.yaml"), []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "stale.yaml"), []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: `\.yaml$`,
+		MaxDepth:    -1,
+		DeleteFiles: true,
+		Snapshot:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	assertContent := func(name, want string) {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(localDir, name))
+		if err != nil || string(content) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", name, want, content, err)
+		}
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	assertContent("a.yaml", "a\n")
+	assertContent("conf.d/b.yaml", "b\n")
+	for _, name := range []string{"a.yaml", "conf.d"} {
+		if target, err := os.Readlink(filepath.Join(localDir, name)); err != nil || target != filepath.Join(snapshotDataLink, name) {
+			t.Errorf("Expected %s to link into %s, got %q (%v)", name, snapshotDataLink, target, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(localDir, "stale.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale.yaml to be removed, got %v", err)
+	}
+	if dirs := generations(t, localDir); len(dirs) != 1 {
+		t.Fatalf("Expected one generation, got %v", dirs)
+	}
+	first := app.currentGeneration()
+
+	// Nothing changed: the active generation stays
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if app.currentGeneration() != first {
+		t.Errorf("Expected no new generation without changes")
+	}
+
+	// A changed file produces a new generation and the old one is cleaned up
+	mu.Lock()
+	listing["/conf.d/"] = `[{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 05:00:00 GMT", "size": 3}]`
+	files["/conf.d/b.yaml"] = "b2\n"
+	mu.Unlock()
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Third sync failed: %v", err)
+	}
+	assertContent("a.yaml", "a\n")
+	assertContent("conf.d/b.yaml", "b2\n")
+	if app.currentGeneration() == first {
+		t.Errorf("Expected a new generation after a change")
+	}
+	if dirs := generations(t, localDir); len(dirs) != 1 {
+		t.Errorf("Expected old generations to be removed, got %v", dirs)
+	}
+
+	// A file removed upstream loses its top-level symlink
+	mu.Lock()
+	listing["/"] = `[{"name": "conf.d", "type": "directory", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT"}]`
+	mu.Unlock()
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Fourth sync failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(localDir, "a.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.yaml to be removed, got %v", err)
+	}
+	assertContent("conf.d/b.yaml", "b2\n")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stateVersion is bumped whenever the state file format changes incompatibly
+const stateVersion = 1
+
+// localFileInfo describes a file as confsync last wrote it to disk
+type localFileInfo struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// stateFileEntry pairs the remote entry a file was synced from with its local state
+type stateFileEntry struct {
+	Remote FileEntry     `json:"remote"`
+	Source int           `json:"source,omitempty"`
+	Local  localFileInfo `json:"local"`
+}
+
+// syncState is the on-disk representation of the sync cache, persisted across restarts
+type syncState struct {
+	Version    int                         `json:"version"`
+	LastSync   time.Time                   `json:"last_sync"`
+	Mirror     string                      `json:"mirror,omitempty"`
+	Files      map[string]stateFileEntry   `json:"files"`
+	Validators map[string]conditionalEntry `json:"validators,omitempty"`
+}
+
+// statePath returns the absolute location of the state file, or "" when persistence is disabled.
+// Relative paths are resolved against the local directory.
+func (app *ConfsyncApp) statePath() string {
+	if app.config.StateFile == "" {
+		return ""
+	}
+	if filepath.IsAbs(app.config.StateFile) {
+		return app.config.StateFile
+	}
+	return filepath.Join(app.config.LocalDir, app.config.StateFile)
+}
+
+// isInternalFile reports whether a path relative to the local directory belongs to confsync itself
+// and must never be treated as a synced file
+func (app *ConfsyncApp) isInternalFile(relPath string) bool {
+	// Generations, ..data and temporary symlinks live next to the synced files in snapshot mode
+	if app.config.Snapshot && strings.HasPrefix(relPath, snapshotNamePrefix) {
+		return true
+	}
+	if strings.HasPrefix(relPath, stagingPrefix) {
+		return true
+	}
+	if relPath == partialDirName || strings.HasPrefix(relPath, partialDirName+"/") {
+		return true
+	}
+
+	if statePath := app.statePath(); statePath != "" {
+		if rel, err := filepath.Rel(app.config.LocalDir, statePath); err == nil {
+			rel = filepath.ToSlash(rel)
+			if relPath == rel || relPath == rel+".tmp" {
+				return true
+			}
+		}
+	}
+
+	if historyPath := app.historyPath(); historyPath != "" {
+		if rel, err := filepath.Rel(app.config.LocalDir, historyPath); err == nil {
+			rel = filepath.ToSlash(rel)
+			if relPath == rel || strings.HasPrefix(relPath, rel+"/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// statLocalFile describes a local file by size, modification time and SHA-256 digest
+func statLocalFile(path string) (localFileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return localFileInfo{}, err
+	}
+
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return localFileInfo{}, err
+	}
+
+	return localFileInfo{Size: info.Size(), ModTime: info.ModTime(), SHA256: digest}, nil
+}
+
+// recordLocalFile remembers how a file looked right after confsync wrote it
+func (app *ConfsyncApp) recordLocalFile(filename string, info localFileInfo) {
+	app.localMu.Lock()
+	app.localFiles[filename] = info
+	app.localMu.Unlock()
+}
+
+// forgetLocalFile drops the recorded local state of a removed file
+func (app *ConfsyncApp) forgetLocalFile(filename string) {
+	app.localMu.Lock()
+	delete(app.localFiles, filename)
+	app.localMu.Unlock()
+}
+
+// checkLocalFile compares a file on disk with what confsync last wrote. A file whose
+// modification time moved is re-hashed, so touching a file doesn't count as a change.
+// It returns the current local state and whether the content is unchanged.
+func (app *ConfsyncApp) checkLocalFile(filename string, recorded localFileInfo) (localFileInfo, bool) {
+	localPath := filepath.Join(app.config.LocalDir, filepath.FromSlash(filename))
+
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != recorded.Size {
+		return recorded, false
+	}
+	if info.ModTime().Equal(recorded.ModTime) {
+		return recorded, true
+	}
+
+	digest, err := fileSHA256(localPath)
+	if err != nil || digest != recorded.SHA256 {
+		return recorded, false
+	}
+	return localFileInfo{Size: info.Size(), ModTime: info.ModTime(), SHA256: digest}, true
+}
+
+// loadState restores the sync cache from the state file. Files that no longer match what
+// is on disk are left out of the cache so they are fetched again on the next sync.
+func (app *ConfsyncApp) loadState() error {
+	path := app.statePath()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+
+	for url, entry := range state.Validators {
+		app.conditionalCache[url] = entry
+	}
+
+	restored, stale := 0, 0
+	for filename, file := range state.Files {
+		if !isSafeRelPath(filename) || !app.fileRegex.MatchString(filename) {
+			continue
+		}
+
+		local, unchanged := app.checkLocalFile(filename, file.Local)
+		// Local changes are left for the drift check to report instead of being overwritten
+		if !unchanged && app.config.DriftCheck != driftCheckOff && app.config.DriftAction == driftActionReport {
+			unchanged = true
+		}
+		if !unchanged {
+			// Without a trustworthy local copy, a 304 must not be accepted for this file
+			app.forgetFileValidators(filename)
+			stale++
+			continue
+		}
+
+		file.Remote.Source = file.Source
+		app.fileCache[filename] = file.Remote
+		app.localFiles[filename] = local
+		restored++
+	}
+
+	app.lastSync = state.LastSync
+	// A mirror that is no longer configured is forgotten, the next sync records the current one
+	if app.mirrors != nil {
+		for _, m := range app.mirrors.mirrors {
+			if m.url == state.Mirror {
+				app.listedFrom = state.Mirror
+			}
+		}
+	}
+	app.setFileSources(app.fileCache)
+
+	app.logger.Printf("Restored sync state for %d files from %s (%d changed on disk and will be fetched again)", restored, path, stale)
+	return nil
+}
+
+// saveState writes the sync cache to the state file atomically
+func (app *ConfsyncApp) saveState() error {
+	path := app.statePath()
+	if path == "" {
+		return nil
+	}
+
+	app.mu.RLock()
+	lastSync := app.lastSync
+	app.mu.RUnlock()
+
+	state := syncState{
+		Version:  stateVersion,
+		LastSync: lastSync,
+		Files:    make(map[string]stateFileEntry, len(app.fileCache)),
+	}
+	if app.mirrors != nil {
+		state.Mirror = app.listedFrom
+	}
+
+	for filename, entry := range app.fileCache {
+		app.localMu.Lock()
+		local, known := app.localFiles[filename]
+		app.localMu.Unlock()
+
+		// Files synced before their local state was tracked are described from disk
+		if !known {
+			info, err := statLocalFile(filepath.Join(app.config.LocalDir, filepath.FromSlash(filename)))
+			if err != nil {
+				continue
+			}
+			local = info
+			app.recordLocalFile(filename, local)
+		}
+
+		state.Files[filename] = stateFileEntry{Remote: entry, Source: entry.Source, Local: local}
+	}
+
+	app.conditionalMu.Lock()
+	state.Validators = make(map[string]conditionalEntry, len(app.conditionalCache))
+	for url, entry := range app.conditionalCache {
+		state.Validators[url] = entry
+	}
+	app.conditionalMu.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		if removeErr := os.Remove(tempPath); removeErr != nil {
+			app.logger.Printf("Failed to remove temporary file %s: %v", tempPath, removeErr)
+		}
+		return fmt.Errorf("failed to move state file into place: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatePersistence(t *testing.T) {
+	files := map[string]string{"a.yaml": "a\n", "b.yaml": "b\n"}
+	var mu sync.Mutex
+	downloads := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2},
+				{"name": "b.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		name := r.URL.Path[1:]
+		mu.Lock()
+		downloads[name]++
+		mu.Unlock()
+		fmt.Fprint(w, files[name])
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	config := Config{
+		RemoteURL:   server.URL + "/",
+		LocalDir:    localDir,
+		FilePattern: ".*",
+		DeleteFiles: true,
+		StateFile:   "state.json",
+	}
+
+	// newApp simulates a restart by creating a fresh app over the same directory
+	newApp := func() *ConfsyncApp {
+		app, err := NewConfsyncApp(config)
+		if err != nil {
+			t.Fatalf("Failed to create app: %v", err)
+		}
+		return app
+	}
+
+	app := newApp()
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	if downloads["a.yaml"] != 1 || downloads["b.yaml"] != 1 {
+		t.Fatalf("Expected one download per file, got %v", downloads)
+	}
+	lastSync := app.lastSync
+
+	// The state file matches the pattern but must survive deletion
+	if _, err := os.Stat(filepath.Join(localDir, "state.json")); err != nil {
+		t.Fatalf("Expected state file to be written: %v", err)
+	}
+
+	app = newApp()
+	if !app.lastSync.Equal(lastSync) {
+		t.Errorf("Expected last sync %v to be restored, got %v", lastSync, app.lastSync)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync after restart failed: %v", err)
+	}
+	if downloads["a.yaml"] != 1 || downloads["b.yaml"] != 1 {
+		t.Errorf("Expected no downloads after restart, got %v", downloads)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "state.json")); err != nil {
+		t.Errorf("Expected state file to survive a sync with deletion enabled: %v", err)
+	}
+
+	// Touching a file is not a change, editing one is
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(localDir, "a.yaml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "b.yaml"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app = newApp()
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync after local edit failed: %v", err)
+	}
+	if downloads["a.yaml"] != 1 {
+		t.Errorf("Expected touched a.yaml not to be downloaded again, got %d downloads", downloads["a.yaml"])
+	}
+	if downloads["b.yaml"] != 2 {
+		t.Errorf("Expected edited b.yaml to be downloaded again, got %d downloads", downloads["b.yaml"])
+	}
+	content, err := os.ReadFile(filepath.Join(localDir, "b.yaml"))
+	if err != nil || string(content) != "b\n" {
+		t.Errorf("Expected b.yaml to be restored, got %q (%v)", content, err)
+	}
+
+	// A corrupt state file is ignored rather than preventing startup
+	if err := os.WriteFile(filepath.Join(localDir, "state.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	app = newApp()
+	if len(app.fileCache) != 0 {
+		t.Errorf("Expected empty cache from a corrupt state file, got %d entries", len(app.fileCache))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stagingPrefix names the directories transactional syncs stage files in, inside the local
+// directory so that committing is a rename on the same filesystem
+const stagingPrefix = ".confsync-staging-"
+
+// transaction stages the changes of a transactional sync so they are applied all together or not at all
+type transaction struct {
+	app *ConfsyncApp
+	// dir holds new/ with the staged files and old/ with backups of the files they replace.
+	// In snapshot mode it is the new generation, which is staged and activated as a whole.
+	dir  string
+	root string
+	// records holds the local state of the affected files from before the sync, restored on abort
+	records map[string]*localFileInfo
+}
+
+// committedChange is one applied step of a commit, undone on rollback
+type committedChange struct {
+	target string
+	// backup holds the previous content of target, or is empty if target didn't exist
+	backup string
+}
+
+// beginTransaction prepares staging for the files about to be synced or removed and remembers
+// their local state. In snapshot mode the new generation is used as staging.
+func (app *ConfsyncApp) beginTransaction(filesToSync []FileEntry, filesToRemove []string, generation string) (*transaction, error) {
+	tx := &transaction{app: app, dir: generation, root: generation}
+	if generation == "" {
+		app.removeStaleStaging()
+
+		if err := os.MkdirAll(app.config.LocalDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", app.config.LocalDir, err)
+		}
+		dir, err := os.MkdirTemp(app.config.LocalDir, stagingPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		tx.dir, tx.root = dir, filepath.Join(dir, "new")
+	}
+
+	filenames := append([]string(nil), filesToRemove...)
+	for _, entry := range filesToSync {
+		filenames = append(filenames, entry.Name)
+	}
+
+	tx.records = make(map[string]*localFileInfo, len(filenames))
+	app.localMu.Lock()
+	for _, filename := range filenames {
+		if info, known := app.localFiles[filename]; known {
+			tx.records[filename] = &info
+		} else {
+			tx.records[filename] = nil
+		}
+	}
+	app.localMu.Unlock()
+
+	return tx, nil
+}
+
+// abort discards everything staged and restores the recorded local state of the affected files
+func (tx *transaction) abort() {
+	tx.restoreRecords()
+	tx.cleanup()
+}
+
+// restoreRecords puts back the local state recorded before the sync for the affected files
+func (tx *transaction) restoreRecords() {
+	tx.app.localMu.Lock()
+	for filename, info := range tx.records {
+		if info != nil {
+			tx.app.localFiles[filename] = *info
+		} else {
+			delete(tx.app.localFiles, filename)
+		}
+	}
+	tx.app.localMu.Unlock()
+}
+
+// cleanup removes the staging directory
+func (tx *transaction) cleanup() {
+	if err := os.RemoveAll(tx.dir); err != nil {
+		tx.app.logger.Printf("Error removing staging directory %s: %v", tx.dir, err)
+	}
+}
+
+// commit moves the staged files into place and removes the given files. If any step fails,
+// the steps already applied are rolled back so the local directory is left as it was.
+func (tx *transaction) commit(staged, removals []string) error {
+	localDir := tx.app.config.LocalDir
+	backupDir := filepath.Join(tx.dir, "old")
+	var applied []committedChange
+
+	err := func() error {
+		for _, filename := range removals {
+			target := filepath.Join(localDir, filepath.FromSlash(filename))
+			backup := filepath.Join(backupDir, filepath.FromSlash(filename))
+			if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(target, backup); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", filename, err)
+			}
+			applied = append(applied, committedChange{target: target, backup: backup})
+		}
+
+		for _, filename := range staged {
+			target := filepath.Join(localDir, filepath.FromSlash(filename))
+			change := committedChange{target: target}
+
+			// Keep the original linked aside, so the rename below still replaces it atomically
+			if _, err := os.Lstat(target); err == nil {
+				change.backup = filepath.Join(backupDir, filepath.FromSlash(filename))
+				if err := os.MkdirAll(filepath.Dir(change.backup), 0755); err != nil {
+					return err
+				}
+				if err := linkOrCopy(target, change.backup); err != nil {
+					return fmt.Errorf("failed to back up %s: %w", filename, err)
+				}
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+			}
+			if err := os.Rename(filepath.Join(tx.root, filepath.FromSlash(filename)), target); err != nil {
+				return fmt.Errorf("failed to move %s into place: %w", filename, err)
+			}
+			applied = append(applied, change)
+		}
+		return nil
+	}()
+
+	if err != nil {
+		for i := len(applied) - 1; i >= 0; i-- {
+			tx.rollback(applied[i])
+		}
+		tx.abort()
+		return fmt.Errorf("commit failed and was rolled back: %w", err)
+	}
+
+	for _, filename := range removals {
+		tx.app.removeEmptyParents(filepath.Join(localDir, filepath.FromSlash(filename)))
+	}
+	tx.cleanup()
+	return nil
+}
+
+// rollback undoes one applied change of a failed commit
+func (tx *transaction) rollback(change committedChange) {
+	var err error
+	if change.backup != "" {
+		err = os.Rename(change.backup, change.target)
+	} else {
+		err = os.Remove(change.target)
+	}
+	if err != nil {
+		tx.app.logger.Printf("Error rolling back %s: %v", change.target, err)
+	}
+}
+
+// removeStaleStaging removes staging directories left behind by an interrupted sync
+func (app *ConfsyncApp) removeStaleStaging() {
+	entries, err := os.ReadDir(app.config.LocalDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), stagingPrefix) {
+			if err := os.RemoveAll(filepath.Join(app.config.LocalDir, entry.Name())); err != nil {
+				app.logger.Printf("Error removing stale staging directory %s: %v", entry.Name(), err)
+			}
+		}
+	}
+}
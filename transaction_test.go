@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTransactionalSync(t *testing.T) {
+	var mu sync.Mutex
+	mtime := "Sun, 27 Jul 2025 04:23:20 GMT"
+	names := []string{"a.yaml", "b.yaml", "c.yaml"}
+	files := map[string]string{"a.yaml": "a\n", "b.yaml": "b\n", "c.yaml": "c\n", "d.yaml": "d\n"}
+	failing := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/" {
+			var entries []string
+			for _, name := range names {
+				entries = append(entries, fmt.Sprintf(`{"name": %q, "type": "file", "mtime": %q, "size": 2}`, name, mtime))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+			return
+		}
+		name := r.URL.Path[1:]
+		if name == failing {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, files[name])
+	}))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:     server.URL + "/",
+		LocalDir:      localDir,
+		FilePattern:   `\.yaml$`,
+		DeleteFiles:   true,
+		Transactional: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	assertContent := func(name, want string) {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(localDir, name))
+		if err != nil || string(content) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", name, want, content, err)
+		}
+	}
+	assertNoStaging := func() {
+		t.Helper()
+		matches, _ := filepath.Glob(filepath.Join(localDir, stagingPrefix+"*"))
+		if len(matches) > 0 {
+			t.Errorf("Expected staging directories to be cleaned up, got %v", matches)
+		}
+	}
+
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	assertNoStaging()
+
+	// a.yaml and b.yaml change, c.yaml is removed upstream, and b.yaml fails to download
+	mu.Lock()
+	mtime = "Sun, 27 Jul 2025 05:00:00 GMT"
+	names = []string{"a.yaml", "b.yaml"}
+	files["a.yaml"], files["b.yaml"] = "A\n", "B\n"
+	failing = "b.yaml"
+	mu.Unlock()
+
+	if err := app.syncFiles(); err == nil {
+		t.Fatalf("Expected the sync to fail")
+	}
+	assertContent("a.yaml", "a\n")
+	assertContent("b.yaml", "b\n")
+	assertContent("c.yaml", "c\n")
+	assertNoStaging()
+	if entry := app.fileCache["a.yaml"]; entry.MTime != "Sun, 27 Jul 2025 04:23:20 GMT" {
+		t.Errorf("Expected the cache to keep the committed entry for a.yaml, got %+v", entry)
+	}
+	if _, ok := app.fileCache["c.yaml"]; !ok {
+		t.Errorf("Expected the cache to keep c.yaml after an aborted sync")
+	}
+
+	// A failure while committing rolls back the files already moved into place
+	mu.Lock()
+	failing = ""
+	names = []string{"a.yaml", "b.yaml", "d.yaml"}
+	mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(localDir, "d.yaml", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.syncFiles(); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected the commit to be rolled back, got %v", err)
+	}
+	assertContent("a.yaml", "a\n")
+	assertContent("b.yaml", "b\n")
+	assertContent("c.yaml", "c\n")
+	assertNoStaging()
+
+	// Once everything succeeds, all changes are applied together
+	if err := os.RemoveAll(filepath.Join(localDir, "d.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.syncFiles(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	assertContent("a.yaml", "A\n")
+	assertContent("b.yaml", "B\n")
+	assertContent("d.yaml", "d\n")
+	if _, err := os.Stat(filepath.Join(localDir, "c.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected c.yaml to be removed, got %v", err)
+	}
+	assertNoStaging()
+}
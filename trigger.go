@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// syncRun is a triggered sync. done is closed once it finished, after which err and duration are set.
+type syncRun struct {
+	done     chan struct{}
+	err      error
+	duration time.Duration
+}
+
+// syncResult is the JSON response of the /sync endpoint
+type syncResult struct {
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Health   *HealthStatus `json:"health,omitempty"`
+}
+
+// triggerSync starts an immediate sync. Triggers arriving before a queued run started are coalesced into it,
+// while a trigger during a running sync queues a new run, so every trigger is followed by a sync that
+// started after it.
+func (app *ConfsyncApp) triggerSync() *syncRun {
+	app.triggerMu.Lock()
+	defer app.triggerMu.Unlock()
+	if app.queuedRun != nil {
+		return app.queuedRun
+	}
+
+	run := &syncRun{done: make(chan struct{})}
+	if err := app.shutdownCtx.Err(); err != nil {
+		run.err = fmt.Errorf("shutting down: %w", err)
+		close(run.done)
+		return run
+	}
+	app.queuedRun = run
+	app.triggeredSyncs.Add(1)
+	go func() {
+		defer app.triggeredSyncs.Done()
+		app.triggerRunMu.Lock()
+		defer app.triggerRunMu.Unlock()
+
+		app.triggerMu.Lock()
+		app.queuedRun = nil
+		app.triggerMu.Unlock()
+
+		start := time.Now()
+		run.err = app.runSync("Triggered sync")
+		run.duration = time.Since(start)
+		close(run.done)
+	}()
+	return run
+}
+
+// syncHandler triggers a sync on POST /sync. With wait=true it responds once the sync finished.
+func (app *ConfsyncApp) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wait := false
+	if value := r.FormValue("wait"); value != "" {
+		var err error
+		if wait, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+	}
+
+	run := app.triggerSync()
+	if !wait {
+		writeSyncResult(w, http.StatusAccepted, syncResult{Status: "queued"})
+		return
+	}
+
+	select {
+	case <-run.done:
+	case <-r.Context().Done():
+		// The client gave up waiting, the sync still completes
+		return
+	}
+
+	health := app.getHealthStatus()
+	result := syncResult{Status: "synced", Duration: run.duration, Health: &health}
+	status := http.StatusOK
+	if run.err != nil {
+		result.Status = "failed"
+		result.Error = run.err.Error()
+		status = http.StatusInternalServerError
+	}
+	writeSyncResult(w, status, result)
+}
+
+// writeSyncResult writes a /sync response
+func writeSyncResult(w http.ResponseWriter, status int, result syncResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Failed to encode sync response: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTriggeredSync(t *testing.T) {
+	var listings int64
+	started := make(chan struct{}, 10)
+	gate := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			atomic.AddInt64(&listings, 1)
+			started <- struct{}{}
+			<-gate
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		fmt.Fprint(w, "a\n")
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:      server.URL + "/",
+		LocalDir:       t.TempDir(),
+		FilePattern:    `\.yaml$`,
+		PollInterval:   time.Minute,
+		ConnectTimeout: 10 * time.Second,
+		AdminTokenFile: tokenFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	request := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sync?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		app.syncHandler(recorder, req)
+		return recorder
+	}
+
+	if code := request("", "wrong").Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", code)
+	}
+
+	// Triggers arriving while a sync runs are coalesced into a single queued run
+	if code := request("", "secret").Code; code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a trigger without waiting, got %d", code)
+	}
+	<-started
+	queued := app.triggerSync()
+	for i := 0; i < 3; i++ {
+		if run := app.triggerSync(); run != queued {
+			t.Errorf("Expected trigger %d to join the queued run", i)
+		}
+	}
+	close(gate)
+	<-queued.done
+	if queued.err != nil {
+		t.Errorf("Queued sync failed: %v", queued.err)
+	}
+	if n := atomic.LoadInt64(&listings); n != 2 {
+		t.Errorf("Expected 2 syncs for 5 triggers, got %d", n)
+	}
+
+	// Waiting returns the result of the sync
+	resp := request("wait=true", "secret")
+	var result syncResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid response %q: %v", resp.Body.String(), err)
+	}
+	if resp.Code != http.StatusOK || result.Status != "synced" || result.Health == nil || result.Health.SyncedFiles != 1 {
+		t.Errorf("Expected a successful sync result, got %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestDrainWaitsForTriggeredSync(t *testing.T) {
+	downloading := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name": "a.yaml", "type": "file", "mtime": "Sun, 27 Jul 2025 04:23:20 GMT", "size": 2}]`)
+			return
+		}
+		// The download hangs until drain cancels it
+		close(downloading)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	app, err := NewConfsyncApp(Config{
+		RemoteURL:    server.URL + "/",
+		LocalDir:     t.TempDir(),
+		FilePattern:  `\.yaml$`,
+		PollInterval: time.Minute,
+		RetryDelay:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	run := app.triggerSync()
+	<-downloading
+	drained := make(chan struct{})
+	go func() {
+		app.drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected drain to cancel the triggered sync")
+	}
+	select {
+	case <-run.done:
+	default:
+		t.Errorf("Expected drain to wait for the triggered sync to finish")
+	}
+
+	// Triggers after drain don't start a sync
+	if run := app.triggerSync(); run.err == nil {
+		t.Errorf("Expected a trigger after drain to fail")
+	}
+}
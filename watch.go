@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Watch modes
+const (
+	watchModeSSE      = "sse"
+	watchModeLongPoll = "longpoll"
+)
+
+// watchRevisionLimit caps the size of a revision read from a long-poll response body
+const watchRevisionLimit = 4096
+
+// watchMinInterval is the minimum time between long-poll requests that didn't announce a new revision
+const watchMinInterval = time.Second
+
+// watcher keeps a connection to a server-sent events stream or long-poll endpoint open and triggers a sync
+// whenever the server announces a new revision
+type watcher struct {
+	url         string
+	mode        string
+	userAgent   string
+	client      *http.Client
+	idleTimeout time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	revision    string
+	connected   int32
+	logger      *log.Logger
+}
+
+// newWatcher creates a watcher from the configuration, or returns nil when watch mode is disabled
+func newWatcher(config Config, client *http.Client, logger *log.Logger) (*watcher, error) {
+	if config.Watch == "" {
+		return nil, nil
+	}
+
+	switch config.WatchMode {
+	case "":
+		config.WatchMode = watchModeSSE
+	case watchModeSSE, watchModeLongPoll:
+	default:
+		return nil, fmt.Errorf("invalid watch mode %q: must be one of sse, longpoll", config.WatchMode)
+	}
+
+	// The watch endpoint may be given relative to the remote URL
+	base, err := url.Parse(strings.TrimSuffix(config.RemoteURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL: %w", err)
+	}
+	ref, err := url.Parse(config.Watch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watch URL: %w", err)
+	}
+	watchURL := base.ResolveReference(ref)
+	if watchURL.Scheme != "http" && watchURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid watch URL %q: must be http or https", redactURL(watchURL.String()))
+	}
+
+	minBackoff := config.RetryDelay
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	maxBackoff := config.WatchMaxBackoff
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+
+	return &watcher{
+		url:         watchURL.String(),
+		mode:        config.WatchMode,
+		userAgent:   config.UserAgent,
+		client:      client,
+		idleTimeout: config.WatchIdleTimeout,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		logger:      logger,
+	}, nil
+}
+
+// isConnected reports whether the watch connection is currently established
+func (w *watcher) isConnected() bool {
+	return atomic.LoadInt32(&w.connected) == 1
+}
+
+// setConnected records whether the watch connection is established
+func (w *watcher) setConnected(connected bool) {
+	value := int32(0)
+	if connected {
+		value = 1
+	}
+	atomic.StoreInt32(&w.connected, value)
+}
+
+// run watches until ctx is cancelled, calling onChange for every new revision. Lost connections are
+// re-established with exponential backoff; a sync is triggered after reconnecting, since announcements
+// may have been missed in between.
+func (w *watcher) run(ctx context.Context, onChange func()) {
+	backoff := w.minBackoff
+	reconnecting := false
+	for {
+		established, err := w.watchOnce(ctx, func() {
+			w.setConnected(true)
+			if reconnecting {
+				w.logger.Printf("Watch stream reconnected")
+				onChange()
+			}
+		}, onChange)
+		w.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if established {
+			backoff = w.minBackoff
+		}
+		reconnecting = true
+
+		w.logger.Printf("Watch stream unavailable, falling back to polling and reconnecting in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// watchOnce runs a single connection until it fails. onConnect is called once the server accepted the
+// connection, and the result reports whether that happened.
+func (w *watcher) watchOnce(ctx context.Context, onConnect, onChange func()) (bool, error) {
+	if w.mode == watchModeLongPoll {
+		return w.longPoll(ctx, onConnect, onChange)
+	}
+	return w.stream(ctx, onConnect, onChange)
+}
+
+// newRequest creates a watch request that is cancelled when no data arrived within the idle timeout.
+// The returned function resets the idle timer, and the cancel function must be called when done.
+func (w *watcher) newRequest(ctx context.Context, accept string) (*http.Request, func(), func(), error) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	cancel := func() { cancelCause(nil) }
+	touch := func() {}
+	if w.idleTimeout > 0 {
+		timer := time.AfterFunc(w.idleTimeout, func() {
+			cancelCause(fmt.Errorf("no data received for %v", w.idleTimeout))
+		})
+		touch = func() { timer.Reset(w.idleTimeout) }
+		cancel = func() {
+			timer.Stop()
+			cancelCause(nil)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	req.Header.Set("User-Agent", w.userAgent)
+	req.Header.Set("Accept", accept)
+	return req, touch, cancel, nil
+}
+
+// requestError replaces the error of a request cancelled by its idle timeout with the reason
+func requestError(req *http.Request, err error) error {
+	if cause := context.Cause(req.Context()); cause != nil && cause != context.Canceled {
+		return cause
+	}
+	return err
+}
+
+// stream reads a server-sent events stream. Every event announces a revision: its id, or its data if it
+// has no id. Comment lines can be used as heartbeats to keep the idle timeout from expiring.
+func (w *watcher) stream(ctx context.Context, onConnect, onChange func()) (bool, error) {
+	req, touch, cancel, err := w.newRequest(ctx, "text/event-stream")
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	req.Header.Set("Cache-Control", "no-cache")
+	if w.revision != "" {
+		req.Header.Set("Last-Event-ID", w.revision)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false, requestError(req, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		return false, fmt.Errorf("unexpected content type %q", contentType)
+	}
+	onConnect()
+
+	var id string
+	var data []string
+	hasID := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		touch()
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event
+			revision := strings.Join(data, "\n")
+			if hasID {
+				revision = id
+			}
+			if revision != "" {
+				w.announce(revision, onChange)
+			}
+			id, data, hasID = "", nil, false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id, hasID = value, true
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, requestError(req, err)
+	}
+	return true, io.ErrUnexpectedEOF
+}
+
+// longPoll repeatedly asks the server for a revision newer than the last one. The server holds the
+// request until the revision changes and responds with the new revision in the ETag header or the body,
+// or answers 304 Not Modified when it gives up waiting.
+func (w *watcher) longPoll(ctx context.Context, onConnect, onChange func()) (bool, error) {
+	established := false
+	for {
+		start := time.Now()
+		changed, err := w.poll(ctx, onChange)
+		if err != nil {
+			return established, err
+		}
+		if !established {
+			established = true
+			onConnect()
+		}
+
+		// Don't hammer a server that answers immediately without a new revision
+		if !changed {
+			if wait := watchMinInterval - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return established, ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+}
+
+// poll performs a single long-poll request and reports whether it announced a new revision
+func (w *watcher) poll(ctx context.Context, onChange func()) (bool, error) {
+	req, _, cancel, err := w.newRequest(ctx, "*/*")
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	if w.revision != "" {
+		req.Header.Set("If-None-Match", w.revision)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false, requestError(req, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	revision := resp.Header.Get("ETag")
+	if revision == "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, watchRevisionLimit))
+		if err != nil {
+			return false, requestError(req, err)
+		}
+		revision = strings.TrimSpace(string(body))
+	}
+	if revision == "" || revision == w.revision {
+		return false, nil
+	}
+	w.announce(revision, onChange)
+	return true, nil
+}
+
+// announce records a revision announced by the server and calls onChange if it is new
+func (w *watcher) announce(revision string, onChange func()) {
+	if revision == w.revision {
+		return
+	}
+	w.logger.Printf("Server announced revision %s", revision)
+	w.revision = revision
+	onChange()
+}
+
+// watchConnected reports whether watch mode is enabled and its connection established
+func (app *ConfsyncApp) watchConnected() bool {
+	return app.watcher != nil && app.watcher.isConnected()
+}
+
+// watchDescription describes the watch endpoint, or "disabled"
+func (app *ConfsyncApp) watchDescription() string {
+	if app.watcher == nil {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s (%s)", redactURL(app.watcher.url), app.watcher.mode)
+}
+
+// boolToInt converts a bool to a 0 or 1 metric value
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// expectChanges waits for n onChange calls and fails on any further one
+func expectChanges(t *testing.T, changes chan struct{}, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d changes, got %d", n, i)
+		}
+	}
+	select {
+	case <-changes:
+		t.Errorf("Expected exactly %d changes", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchSSE(t *testing.T) {
+	var mu sync.Mutex
+	var connections int
+	var lastEventIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		connection := connections
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		if connection == 1 {
+			// A repeated revision and heartbeats don't trigger a sync
+			fmt.Fprint(w, "id: r1\ndata: published\n\n: heartbeat\n\nid: r1\n\n")
+			flusher.Flush()
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, "event: revision\ndata: r2\n\n")
+			flusher.Flush()
+			// The stream ends, the watcher reconnects
+			return
+		}
+		flusher.Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	w, err := newWatcher(Config{
+		RemoteURL:       server.URL + "/configs/",
+		Watch:           "/events",
+		WatchMode:       watchModeSSE,
+		RetryDelay:      10 * time.Millisecond,
+		WatchMaxBackoff: time.Second,
+	}, http.DefaultClient, log.Default())
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if w.url != server.URL+"/events" {
+		t.Errorf("Expected the watch URL to be resolved against the remote URL, got %s", w.url)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	changes := make(chan struct{}, 10)
+	go func() {
+		w.run(ctx, func() { changes <- struct{}{} })
+		close(done)
+	}()
+
+	// r1, r2 and the reconnection
+	expectChanges(t, changes, 3)
+	if !w.isConnected() {
+		t.Error("Expected the watcher to be connected")
+	}
+	mu.Lock()
+	if fmt.Sprint(lastEventIDs) != "[ r2]" {
+		t.Errorf("Expected the last revision to be sent on reconnect, got %q", lastEventIDs)
+	}
+	mu.Unlock()
+
+	cancel()
+	<-done
+	if w.isConnected() {
+		t.Error("Expected the watcher to be disconnected after stopping")
+	}
+}
+
+func TestWatchLongPoll(t *testing.T) {
+	var mu sync.Mutex
+	revision := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := revision
+		mu.Unlock()
+		if r.Header.Get("If-None-Match") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", current)
+	}))
+	defer server.Close()
+
+	w, err := newWatcher(Config{
+		RemoteURL:        server.URL + "/",
+		Watch:            server.URL + "/watch",
+		WatchMode:        watchModeLongPoll,
+		WatchIdleTimeout: time.Second,
+		RetryDelay:       10 * time.Millisecond,
+	}, http.DefaultClient, log.Default())
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go w.run(ctx, func() { changes <- struct{}{} })
+
+	expectChanges(t, changes, 1)
+	mu.Lock()
+	revision = `"v2"`
+	mu.Unlock()
+	expectChanges(t, changes, 1)
+}
+
+func TestWatchConfigValidation(t *testing.T) {
+	if _, err := newWatcher(Config{RemoteURL: "https://example.com/", Watch: "/events", WatchMode: "websocket"}, http.DefaultClient, log.Default()); err == nil {
+		t.Error("Expected an error for an unknown watch mode")
+	}
+	if _, err := newWatcher(Config{RemoteURL: "https://example.com/", Watch: "ftp://example.com/events"}, http.DefaultClient, log.Default()); err == nil {
+		t.Error("Expected an error for a non-HTTP watch URL")
+	}
+}
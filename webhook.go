@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook events
+const (
+	eventFilesChanged    = "files_changed"
+	eventSyncFailed      = "sync_failed"
+	eventHealthChanged   = "health_changed"
+	eventSignatureFailed = "signature_failed"
+)
+
+// webhookSignatureHeader carries the HMAC-SHA256 of the request body when a webhook secret is configured
+const webhookSignatureHeader = "X-Confsync-Signature"
+
+// webhookEvents lists the events a webhook can subscribe to
+var webhookEvents = []string{eventFilesChanged, eventSyncFailed, eventHealthChanged, eventSignatureFailed}
+
+// webhookPayload is the JSON body posted to webhooks
+type webhookPayload struct {
+	Event          string       `json:"event"`
+	Job            string       `json:"job,omitempty"`
+	Time           time.Time    `json:"time"`
+	Changes        *syncChanges `json:"changes,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	PreviousStatus string       `json:"previous_status,omitempty"`
+	Health         HealthStatus `json:"health"`
+}
+
+// webhookNotifier posts events to the configured webhook URLs in the background
+type webhookNotifier struct {
+	urls       []string
+	events     map[string]bool
+	secret     []byte
+	client     *http.Client
+	userAgent  string
+	retries    int
+	retryDelay time.Duration
+	pending    sync.WaitGroup
+	logger     *log.Logger
+}
+
+// newWebhookNotifier creates a notifier from the configuration, or returns nil when no webhook is configured
+func newWebhookNotifier(config Config, logger *log.Logger) (*webhookNotifier, error) {
+	if config.WebhookURL == "" {
+		return nil, nil
+	}
+
+	notifier := &webhookNotifier{
+		events:     make(map[string]bool),
+		client:     &http.Client{Timeout: config.WebhookTimeout},
+		userAgent:  config.UserAgent,
+		retries:    config.WebhookRetries,
+		retryDelay: config.RetryDelay,
+		logger:     logger,
+	}
+	for _, target := range strings.Split(config.WebhookURL, ",") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", redactURL(target))
+		}
+		notifier.urls = append(notifier.urls, target)
+	}
+
+	for _, event := range strings.Split(config.WebhookEvents, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("invalid webhook event %q: must be one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+		notifier.events[event] = true
+	}
+
+	if config.WebhookSecretFile != "" {
+		data, err := os.ReadFile(config.WebhookSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook secret: %w", err)
+		}
+		if notifier.secret = bytes.TrimSpace(data); len(notifier.secret) == 0 {
+			return nil, fmt.Errorf("webhook secret file %s is empty", config.WebhookSecretFile)
+		}
+	}
+
+	return notifier, nil
+}
+
+// isWebhookEvent reports whether event is a known webhook event
+func isWebhookEvent(event string) bool {
+	for _, known := range webhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// wants reports whether the event passes the event filter. An empty filter accepts every event.
+func (n *webhookNotifier) wants(event string) bool {
+	return len(n.events) == 0 || n.events[event]
+}
+
+// send delivers the payload to every webhook URL in the background
+func (n *webhookNotifier) send(payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.logger.Printf("Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	for _, target := range n.urls {
+		n.pending.Add(1)
+		go func(target string) {
+			defer n.pending.Done()
+			if err := n.deliver(target, payload.Event, body); err != nil {
+				n.logger.Printf("Webhook %s for %s failed: %v", redactURL(target), payload.Event, err)
+			}
+		}(target)
+	}
+}
+
+// deliver posts the body to a webhook URL, retrying with exponential backoff on errors and non-2xx responses
+func (n *webhookNotifier) deliver(target, event string, body []byte) error {
+	var lastErr error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(n.retryDelay * time.Duration(1<<uint(attempt-1)))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", n.userAgent)
+		req.Header.Set("X-Confsync-Event", event)
+		if n.secret != nil {
+			req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookBody(n.secret, body))
+		}
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// Drain the body so the connection can be reused
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			n.logger.Printf("Failed to read webhook response body: %v", err)
+		}
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			n.logger.Printf("Failed to close response body: %v", closeErr)
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("failed after %d retries: %w", n.retries, lastErr)
+}
+
+// wait waits up to timeout for pending deliveries and reports whether all of them finished
+func (n *webhookNotifier) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// signWebhookBody returns the hex-encoded HMAC-SHA256 of body
+func signWebhookBody(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactURL hides credentials and the query, which often carries tokens, from logged webhook URLs
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.Redacted()
+}
+
+// notify sends an event to the webhooks if it passes the event filter
+func (app *ConfsyncApp) notify(payload webhookPayload) {
+	if app.webhooks == nil || !app.webhooks.wants(payload.Event) {
+		return
+	}
+	payload.Job = app.jobName
+	payload.Time = time.Now()
+	payload.Health = app.getHealthStatus()
+	app.webhooks.send(payload)
+}
+
+// syncFailed notifies the webhooks of a failed sync. Signature verification failures are reported as their own event.
+func (app *ConfsyncApp) syncFailed(err error) {
+	event := eventSyncFailed
+	if errors.Is(err, errSignatureInvalid) {
+		event = eventSignatureFailed
+	}
+	app.notify(webhookPayload{Event: event, Error: err.Error()})
+}
+
+// checkHealthTransition notifies the webhooks when the health status changed since the last check
+func (app *ConfsyncApp) checkHealthTransition() {
+	status := app.getHealthStatus().Status
+
+	app.mu.Lock()
+	previous := app.reportedStatus
+	app.reportedStatus = status
+	app.mu.Unlock()
+
+	if previous != status {
+		app.logger.Printf("Health status changed from %s to %s", previous, status)
+		app.notify(webhookPayload{Event: eventHealthChanged, PreviousStatus: previous})
+	}
+}
+
+// webhookCount returns the number of configured webhook URLs
+func (app *ConfsyncApp) webhookCount() int {
+	if app.webhooks == nil {
+		return 0
+	}
+	return len(app.webhooks.urls)
+}